@@ -0,0 +1,32 @@
+// Command prune-embeddings deletes cached embeddings that haven't been used
+// for a while, e.g. after switching embedding models.
+//
+//	go run ./cmd/prune-embeddings -older-than 720h [-model text-embedding-3-small]
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/services"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	olderThan := flag.Duration("older-than", 30*24*time.Hour, "delete embeddings not used for this long")
+	model := flag.String("model", "", "only prune embeddings of this model")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Fatal("Error loading .env file", err)
+	}
+	database.Connect()
+
+	deleted, err := services.PruneEmbeddingCache(time.Now().Add(-*olderThan), *model)
+	if err != nil {
+		log.Fatal("Failed to prune embedding cache: ", err)
+	}
+	log.Printf("Pruned %d cached embeddings", deleted)
+}
@@ -0,0 +1,280 @@
+// Package dbtest points database.DB at an in-memory database, so code built
+// on it can be tested without Postgres.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// memDB is a database/sql driver keeping rows in memory. It understands the
+// statements gorm builds for the chat flow: inserts, updates by column and
+// selects filtered by equality, IN and IS NULL. Aggregates count nothing.
+// Transactions are not isolated.
+type memDB struct {
+	mu     sync.Mutex
+	tables map[string][]map[string]driver.Value
+}
+
+var memDBs sync.Map
+
+// Use points database.DB at an empty in-memory database until the test ends.
+func Use(t testing.TB) {
+	t.Helper()
+	name := t.Name()
+	memDBs.Store(name, &memDB{tables: map[string][]map[string]driver.Value{}})
+	sqlDB, err := sql.Open("memdb", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DB
+	database.DB, err = gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.DB = previous
+		sqlDB.Close()
+		memDBs.Delete(name)
+	})
+}
+
+func init() {
+	sql.Register("memdb", memDriver{})
+}
+
+type memDriver struct{}
+
+func (memDriver) Open(name string) (driver.Conn, error) {
+	db, ok := memDBs.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("memdb: no database %s", name)
+	}
+	return &memConn{db: db.(*memDB)}, nil
+}
+
+type memConn struct {
+	db *memDB
+}
+
+func (c *memConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("memdb: prepared statements are not supported")
+}
+func (c *memConn) Close() error              { return nil }
+func (c *memConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *memConn) Commit() error             { return nil }
+func (c *memConn) Rollback() error           { return nil }
+
+var (
+	memInsert    = regexp.MustCompile(`^INSERT INTO "(\w+)" \(([^)]*)\) VALUES (.*?)(?: RETURNING (.*))?$`)
+	memUpdate    = regexp.MustCompile(`^UPDATE "(\w+)" SET (.*?) WHERE (.*)$`)
+	memSelect    = regexp.MustCompile(`^SELECT (.*?) FROM "(\w+)"(?: WHERE (.*?))?(?: ORDER BY .*?)?(?: LIMIT \$(\d+))?$`)
+	memAggregate = regexp.MustCompile(`^SELECT (?i:count|coalesce|sum)\(`)
+	memCondition = regexp.MustCompile(`^(?:"?\w+"?\.)?"?(\w+)"? (?:= \$(\d+)|IN \(([^)]*)\)|(IS NULL))$`)
+	memParameter = regexp.MustCompile(`\$(\d+)`)
+)
+
+func (c *memConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := c.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows.(*memRows).values)), nil
+}
+
+func (c *memConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	if m := memInsert.FindStringSubmatch(query); m != nil {
+		return c.db.insert(m[1], columnNames(m[2]), m[3], columnNames(m[4]), args)
+	}
+	if m := memUpdate.FindStringSubmatch(query); m != nil {
+		return c.db.update(m[1], m[2], m[3], args)
+	}
+	if memAggregate.MatchString(query) {
+		// count(*), SUM(...): nothing is counted.
+		return &memRows{columns: []string{"count"}, values: [][]driver.Value{{int64(0)}}}, nil
+	}
+	if m := memSelect.FindStringSubmatch(query); m != nil {
+		return c.db.selectRows(m[2], m[1], m[3], m[4], args)
+	}
+	return nil, fmt.Errorf("memdb: unsupported statement %s", query)
+}
+
+func columnNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.Trim(strings.TrimSpace(name), `"`); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func arg(args []driver.NamedValue, index string) driver.Value {
+	i, _ := strconv.Atoi(index)
+	return args[i-1].Value
+}
+
+func (db *memDB) insert(table string, columns []string, values string, returning []string, args []driver.NamedValue) (driver.Rows, error) {
+	result := &memRows{columns: returning}
+	for _, tuple := range strings.Split(values, "),(") {
+		parameters := memParameter.FindAllStringSubmatch(tuple, -1)
+		if len(parameters) != len(columns) {
+			return nil, fmt.Errorf("memdb: unsupported values %s", tuple)
+		}
+		row := map[string]driver.Value{"id": int64(len(db.tables[table]) + 1)}
+		for i, column := range columns {
+			row[column] = arg(args, parameters[i][1])
+		}
+		db.tables[table] = append(db.tables[table], row)
+		result.values = append(result.values, project(row, returning))
+	}
+	return result, nil
+}
+
+func (db *memDB) update(table string, assignments string, where string, args []driver.NamedValue) (driver.Rows, error) {
+	matched, err := db.filter(table, where, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, assignment := range strings.Split(assignments, ",") {
+		column, value, _ := strings.Cut(assignment, "=")
+		parameter := memParameter.FindStringSubmatch(value)
+		if parameter == nil || strings.TrimSpace(value) != parameter[0] {
+			continue // Expressions such as counters are left alone.
+		}
+		for _, row := range matched {
+			row[strings.Trim(strings.TrimSpace(column), `"`)] = arg(args, parameter[1])
+		}
+	}
+	return &memRows{values: make([][]driver.Value, len(matched))}, nil
+}
+
+func (db *memDB) selectRows(table string, selected string, where string, limit string, args []driver.NamedValue) (driver.Rows, error) {
+	matched, err := db.filter(table, where, args)
+	if err != nil {
+		return nil, err
+	}
+	if limit != "" {
+		if n, ok := arg(args, limit).(int64); ok && int(n) < len(matched) {
+			matched = matched[:n]
+		}
+	}
+
+	result := &memRows{columns: columnNames(selected)}
+	if selected == "*" {
+		result.columns = nil
+		for _, row := range matched {
+			for column := range row {
+				if !containsString(result.columns, column) {
+					result.columns = append(result.columns, column)
+				}
+			}
+		}
+	}
+	for _, row := range matched {
+		result.values = append(result.values, project(row, result.columns))
+	}
+	return result, nil
+}
+
+// filter returns the rows of table matching every condition of where.
+func (db *memDB) filter(table string, where string, args []driver.NamedValue) ([]map[string]driver.Value, error) {
+	var conditions [][]string
+	if where != "" {
+		for _, condition := range strings.Split(where, " AND ") {
+			// Grouped conditions are joined by AND as well.
+			condition = strings.TrimLeft(condition, "(")
+			for strings.Count(condition, ")") > strings.Count(condition, "(") {
+				condition = strings.TrimSuffix(condition, ")")
+			}
+			m := memCondition.FindStringSubmatch(condition)
+			if m == nil {
+				return nil, fmt.Errorf("memdb: unsupported condition %s", condition)
+			}
+			conditions = append(conditions, m)
+		}
+	}
+
+	var matched []map[string]driver.Value
+rows:
+	for _, row := range db.tables[table] {
+		for _, m := range conditions {
+			value := row[m[1]]
+			switch {
+			case m[4] != "":
+				if value != nil {
+					continue rows
+				}
+			case m[2] != "":
+				if !sameValue(value, arg(args, m[2])) {
+					continue rows
+				}
+			default:
+				found := false
+				for _, parameter := range memParameter.FindAllStringSubmatch(m[3], -1) {
+					found = found || sameValue(value, arg(args, parameter[1]))
+				}
+				if !found {
+					continue rows
+				}
+			}
+		}
+		matched = append(matched, row)
+	}
+	return matched, nil
+}
+
+func sameValue(a, b driver.Value) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func project(row map[string]driver.Value, columns []string) []driver.Value {
+	values := make([]driver.Value, len(columns))
+	for i, column := range columns {
+		values[i] = row[column]
+	}
+	return values
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+type memRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *memRows) Columns() []string { return r.columns }
+func (r *memRows) Close() error      { return nil }
+
+func (r *memRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"crypto/subtle"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// requireAdmin lets through requests carrying ADMIN_TOKEN as a bearer token.
+// The admin API is disabled while ADMIN_TOKEN is unset.
+func requireAdmin(c *fiber.Ctx) error {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Admin API is disabled"})
+	}
+
+	given, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	return c.Next()
+}
+
+// SetUserPlan moves a user to a plan: "free", "pro", or "custom" with its
+// own limits.
+func SetUserPlan(c *fiber.Ctx) error {
+	type Request struct {
+		Plan           string `json:"plan"`
+		MessagesPerDay int    `json:"messages_per_day"`
+		TokensPerMonth int    `json:"tokens_per_month"`
+		MaxChats       int    `json:"max_chats"`
+	}
+
+	var req Request
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	limits := services.Plan{
+		MessagesPerDay: req.MessagesPerDay,
+		TokensPerMonth: req.TokensPerMonth,
+		MaxChats:       req.MaxChats,
+	}
+	if err := services.SetPlan(&user, req.Plan, limits); err != nil {
+		if errors.Is(err, services.ErrInvalidPlan) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update plan"})
+	}
+	return c.JSON(user)
+}
+
+func Admin(app fiber.Router) {
+	api := app.Group("/admin", requireAdmin)
+	api.Put("/users/:id/plan", SetUserPlan)
+}
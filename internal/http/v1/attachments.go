@@ -0,0 +1,130 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+
+	"github.com/LDTorres/golang-chat-ai/internal/services"
+	"github.com/LDTorres/golang-chat-ai/internal/storage"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const defaultAttachmentsDir = "data/attachments"
+
+// initAttachments stores attachments in ATTACHMENTS_DIR, or refuses them when
+// ATTACHMENTS_DIR is "off".
+func initAttachments() {
+	dir := os.Getenv("ATTACHMENTS_DIR")
+	if dir == "off" {
+		return
+	}
+	if dir == "" {
+		dir = defaultAttachmentsDir
+	}
+
+	store, err := storage.NewLocal(dir)
+	if err != nil {
+		log.Error("Failed to open the attachments directory, attachments are disabled: ", err)
+		return
+	}
+	chatService.Attachments = services.NewAttachments(store)
+	chatService.Memory.Attachments = chatService.Attachments
+}
+
+// parseUploads reads the files of a multipart request's "attachments" field.
+// Requests of any other type have none.
+func parseUploads(c *fiber.Ctx) ([]services.Upload, error) {
+	if !strings.HasPrefix(string(c.Request().Header.ContentType()), fiber.MIMEMultipartForm) {
+		return nil, nil
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+
+	files := form.File["attachments"]
+	if len(files) > services.MaxAttachments {
+		return nil, services.ErrTooManyAttachments
+	}
+	uploads := make([]services.Upload, 0, len(files))
+	for _, header := range files {
+		if header.Size > services.MaxAttachmentSize {
+			return nil, fmt.Errorf("%w: %s", services.ErrAttachmentTooLarge, header.Filename)
+		}
+		data, err := readUpload(header)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, services.Upload{FileName: header.Filename, Data: data})
+	}
+
+	if _, err := chatService.Attachments.Validate(uploads); err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+func readUpload(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// attachmentError writes the response for attachments that can't be
+// accepted.
+func attachmentError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrAttachmentTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrAttachmentType):
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrTooManyAttachments), errors.Is(err, services.ErrAttachmentsDisabled):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrVisionUnsupported):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+	log.Error("Failed to read attachments: ", err)
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+}
+
+// GetAttachment sends the content of an attachment.
+func GetAttachment(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid attachment ID"})
+	}
+
+	attachment, err := services.GetAttachment(uint(id))
+	if errors.Is(err, services.ErrAttachmentNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Attachment not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch attachment"})
+	}
+
+	file, err := chatService.Attachments.Open(attachment)
+	switch {
+	case errors.Is(err, services.ErrAttachmentNotFound), errors.Is(err, services.ErrAttachmentsDisabled):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Attachment not found"})
+	case err != nil:
+		log.Error("Failed to open attachment ", attachment.ID, ": ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch attachment"})
+	}
+
+	c.Set(fiber.HeaderContentType, attachment.MIMEType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", attachment.FileName))
+	c.Set("X-Content-Type-Options", "nosniff")
+	return c.SendStream(file, int(attachment.Size))
+}
+
+func Attachments(app fiber.Router) {
+	app.Get("/attachments/:id", GetAttachment)
+}
@@ -1,38 +1,198 @@
 package v1
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/LDTorres/golang-chat-ai/internal/database"
 	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
 	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/services"
+	"github.com/LDTorres/golang-chat-ai/internal/tokenizer"
+	"github.com/LDTorres/golang-chat-ai/internal/tools"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm"
 )
 
 // Initialize LLM provider
 var llmProvider llm.LLMProvider
 
+// llmCloser is the provider again when it buffers something that must be
+// written before exiting, like a recorded cassette.
+var llmCloser io.Closer
+
+var chatService *services.ChatService
+
+var embeddingCache *services.CachedEmbedder
+
+var modelCatalog *llm.Catalog
+
+const defaultModelsRefresh = 5 * time.Minute
+
+const defaultLLMTimeout = 2 * time.Minute
+
 func InitLLM() {
 	var err error
 	llmProvider, err = llm.NewLLMProvider()
+	if err != nil && os.Getenv("LLM_PROVIDER") == "replay" {
+		// Replays back tests, which must not quietly fall back to the mock.
+		log.Fatal("Failed to load the LLM cassette: ", err)
+	}
 	if err != nil {
 		// Fallback to mock if config fails or not set, or handle error
 		// For now, let's just log and use mock if it fails, or maybe panic?
 		// Given the requirements, let's try to be robust.
+		log.Error("Failed to configure LLM provider, using mock: ", err)
 		llmProvider = &llm.MockLLM{}
 	}
+	llmCloser, _ = llmProvider.(io.Closer)
+
+	// Models are discovered now and refreshed every LLM_MODELS_REFRESH.
+	modelCatalog = llm.NewCatalog(llmProvider)
+	refresh, err := time.ParseDuration(os.Getenv("LLM_MODELS_REFRESH"))
+	if err != nil || refresh <= 0 {
+		refresh = defaultModelsRefresh
+	}
+	modelCatalog.Start(refresh)
+
+	// Embeddings are cached unless EMBEDDING_CACHE=false.
+	if cache, err := strconv.ParseBool(os.Getenv("EMBEDDING_CACHE")); err != nil || cache {
+		size, err := strconv.Atoi(os.Getenv("EMBEDDING_CACHE_SIZE"))
+		if err != nil {
+			size = services.DefaultEmbeddingCacheSize
+		}
+		embeddingCache = services.NewCachedEmbedder(llmProvider, size)
+		llmProvider = embeddingCache
+	}
+
+	timeout, err := time.ParseDuration(os.Getenv("LLM_TIMEOUT"))
+	if err != nil {
+		timeout = defaultLLMTimeout
+	}
+	chatService = services.NewChatService(llmProvider, timeout)
+	chatService.Catalog = modelCatalog
+	initAttachments()
+
+	if budget, err := strconv.Atoi(os.Getenv("LLM_HISTORY_TOKEN_BUDGET")); err == nil {
+		chatService.Memory.TokenBudget = budget
+	}
+	if reply, err := strconv.Atoi(os.Getenv("LLM_REPLY_TOKENS")); err == nil {
+		chatService.Memory.ReplyTokens = reply
+	}
+
+	// LLM_TOOLS lists the builtin tools the model may call, e.g.
+	// "current_time".
+	if names := os.Getenv("LLM_TOOLS"); names != "" {
+		registry := tools.NewRegistry()
+		for _, name := range strings.Split(names, ",") {
+			tool, ok := tools.Builtin[strings.TrimSpace(name)]
+			if !ok {
+				log.Warn("Unknown tool ", name)
+				continue
+			}
+			if err := registry.Register(tool); err != nil {
+				log.Warn("Failed to register tool: ", err)
+			}
+		}
+		chatService.Tools = registry
+	}
+	if iterations, err := strconv.Atoi(os.Getenv("LLM_MAX_TOOL_ITERATIONS")); err == nil {
+		chatService.MaxToolIterations = iterations
+	}
+
+	// LLM_PRICES_FILE overrides the default prices, as a JSON object of model
+	// prefixes to {"input": ..., "output": ...} USD per million tokens.
+	if path := os.Getenv("LLM_PRICES_FILE"); path != "" {
+		prices, err := services.LoadPrices(path)
+		if err != nil {
+			log.Error("Failed to load LLM prices, using the defaults: ", err)
+		} else {
+			chatService.Prices = prices
+		}
+	}
+
+	// Load the tokenizer now so the first request doesn't wait for the
+	// download.
+	if encoding := tokenizer.LookupModel(llmProvider.DefaultModel()).Encoding; encoding != "" {
+		go tokenizer.Preload(encoding)
+	}
 }
 
-func incrementMessageCount(userID uint) {
-	var user models.User
-	if err := database.DB.First(&user, userID).Error; err == nil {
-		user.MessageCount++
-		database.DB.Save(&user)
+// CloseLLM writes what the LLM provider buffered, such as a cassette being
+// recorded. It is called once the server stopped.
+func CloseLLM() {
+	if llmCloser == nil {
+		return
+	}
+	if err := llmCloser.Close(); err != nil {
+		log.Error("Failed to close the LLM provider: ", err)
 	}
 }
 
+// generationError turns an LLM failure into the response sent to the client.
+func generationError(c *fiber.Ctx, err error) error {
+	log.Error("Generation failed: ", err)
+	if errors.Is(err, llm.ErrSafetyBlocked) || errors.Is(err, llm.ErrUnsupported) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate response"})
+}
+
+// checkQuota writes the error response when the user can't send another
+// message, or start a chat with newChat, and reports whether to go ahead.
+func checkQuota(c *fiber.Ctx, userID uint, newChat bool) (bool, error) {
+	err := services.CheckQuota(userID, newChat)
+	var exceeded *services.QuotaExceededError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exceeded):
+		if retry := exceeded.RetryAfter(); retry > 0 {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(retry.Seconds())+1))
+		}
+		return false, c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error":    "Quota exceeded",
+			"plan":     exceeded.Plan,
+			"exceeded": exceeded.Exceeded,
+			"quotas":   exceeded.Quotas,
+		})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return false, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	default:
+		log.Error("Quota check failed: ", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check quota"})
+	}
+}
+
+// settingsError writes the response for chat settings the backend rejects.
+func settingsError(c *fiber.Ctx, err error) error {
+	var invalid *services.InvalidSettingsError
+	if errors.As(err, &invalid) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":           "Invalid settings",
+			"settings_errors": invalid.Errors,
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save settings"})
+}
+
+// CreateChat starts a chat with its first message. The body is JSON, or a
+// multipart form when images are attached to the message.
 func CreateChat(c *fiber.Ctx) error {
 	type Request struct {
-		UserID  uint   `json:"user_id"`
-		Message string `json:"message"`
+		UserID  uint   `json:"user_id" form:"user_id"`
+		Message string `json:"message" form:"message"`
+		llm.Settings
+		PersonaID *uint `json:"persona_id" form:"persona_id"`
 	}
 
 	var req Request
@@ -40,43 +200,55 @@ func CreateChat(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
-	if len(req.Message) > 300 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Message exceeds 300 characters"})
+	if err := services.ValidateMessage(req.Message); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	uploads, err := parseUploads(c)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+	if err := chatService.CheckAttachments(req.Provider, req.Model, uploads); err != nil {
+		return attachmentError(c, err)
+	}
+
+	if ok, err := checkQuota(c, req.UserID, true); !ok {
+		return err
+	}
+
+	var persona *models.Persona
+	if req.PersonaID != nil {
+		persona, err = services.GetPersona(*req.PersonaID)
+		if errors.Is(err, services.ErrPersonaNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Persona not found"})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create chat"})
+		}
 	}
 
 	// Create Chat
-	chat := models.Chat{
-		UserID: req.UserID,
-		Title:  req.Message, // Use first message as title for now
+	chat, err := chatService.StartChat(req.UserID, req.Message, req.Settings, persona)
+	var invalid *services.InvalidSettingsError
+	if errors.As(err, &invalid) {
+		return settingsError(c, err)
 	}
-	if err := database.DB.Create(&chat).Error; err != nil {
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create chat"})
 	}
 
 	// Save User Message
-	userMsg := models.Message{
-		ChatID:  chat.ID,
-		Role:    "user",
-		Content: req.Message,
+	userMsg, err := chatService.AddUserMessage(chat, req.Message, uploads...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save message"})
 	}
-	database.DB.Create(&userMsg)
-	incrementMessageCount(req.UserID)
-
-	prompt := req.Message
 
 	// Get LLM Response
-	response, _, err := llmProvider.GenerateResponse(prompt, "")
+	ctx, cancel := requestContext(c)
+	assistantMsg, err := chatService.Reply(ctx, chat, userMsg, nil)
+	cancel()
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate response"})
-	}
-
-	// Save Assistant Message
-	assistantMsg := models.Message{
-		ChatID:  chat.ID,
-		Role:    "assistant",
-		Content: response,
+		return generationError(c, err)
 	}
-	database.DB.Create(&assistantMsg)
 
 	return c.JSON(fiber.Map{
 		"chat":     chat,
@@ -93,6 +265,81 @@ func DeleteChat(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusOK)
 }
 
+// UpdateChatSettings changes the model, generation parameters and persona of
+// a chat. Fields left out of the body are kept and null resets one to the
+// default. A new persona only changes the instructions, not the settings.
+func UpdateChatSettings(c *fiber.Ctx) error {
+	chatID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid chat ID"})
+	}
+
+	var chat models.Chat
+	if err := database.DB.First(&chat, chatID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Chat not found"})
+	}
+
+	// Start from the current settings, with null fields zeroed, so the body
+	// only has to carry what changes.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(c.Body(), &fields); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	// The persona is checked first but only saved with the settings.
+	var persona *models.Persona
+	rawPersona, setPersona := fields["persona_id"]
+	delete(fields, "persona_id")
+	if setPersona {
+		var personaID *uint
+		if err := json.Unmarshal(rawPersona, &personaID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid persona_id"})
+		}
+		if personaID != nil {
+			persona, err = services.GetPersona(*personaID)
+			if errors.Is(err, services.ErrPersonaNotFound) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Persona not found"})
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save settings"})
+			}
+		}
+	}
+
+	current, err := json.Marshal(services.ChatSettings(&chat))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save settings"})
+	}
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(current, &merged); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save settings"})
+	}
+	for key, value := range fields {
+		if _, ok := merged[key]; !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown setting " + key})
+		}
+		merged[key] = value
+	}
+	body, err := json.Marshal(merged)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	var settings llm.Settings
+	if err := json.Unmarshal(body, &settings); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	if err := chatService.UpdateSettings(&chat, settings); err != nil {
+		return settingsError(c, err)
+	}
+	if setPersona {
+		if err := services.SetPersona(&chat, persona); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save settings"})
+		}
+	}
+	return c.JSON(chat)
+}
+
 func GetChats(c *fiber.Ctx) error {
 	userID := c.Params("id")
 	var chats []models.Chat
@@ -102,79 +349,195 @@ func GetChats(c *fiber.Ctx) error {
 	return c.JSON(chats)
 }
 
+// GetMessages returns the active branch of the chat, oldest first, or every
+// message of the conversation tree with ?tree=true.
 func GetMessages(c *fiber.Ctx) error {
 	chatID := c.Params("id")
-	var messages []models.Message
-	if err := database.DB.Where("chat_id = ?", chatID).Find(&messages).Error; err != nil {
+	if c.QueryBool("tree") {
+		var messages []models.Message
+		if err := database.DB.Preload("Attachments").Where("chat_id = ?", chatID).Order("id").Find(&messages).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch messages"})
+		}
+		return c.JSON(messages)
+	}
+
+	var chat models.Chat
+	if err := database.DB.First(&chat, chatID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Chat not found"})
+	}
+	messages, err := services.ActiveBranch(&chat)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch messages"})
 	}
 	return c.JSON(messages)
 }
 
-func SendMessage(c *fiber.Ctx) error {
+// SelectBranch switches the chat to the branch through message_id, down to
+// its most recent message, and returns that branch.
+func SelectBranch(c *fiber.Ctx) error {
 	chatID, err := c.ParamsInt("id")
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid chat ID"})
 	}
 
 	type Request struct {
-		Message string `json:"message"`
+		MessageID uint `json:"message_id"`
 	}
 	var req Request
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
 
-	if len(req.Message) > 300 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Message exceeds 300 characters"})
+	var chat models.Chat
+	if err := database.DB.First(&chat, chatID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Chat not found"})
+	}
+
+	messages, err := services.SelectBranch(&chat, req.MessageID)
+	if errors.Is(err, services.ErrMessageNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Message not found in chat"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to switch branch"})
+	}
+	return c.JSON(messages)
+}
+
+// parseMessageRequest reads the chat, the user message and its attachments
+// shared by the message endpoints, writing the error response itself when
+// they are invalid. The body is JSON, or a multipart form with the images in
+// its "attachments" field.
+func parseMessageRequest(c *fiber.Ctx) (*models.Chat, string, []services.Upload, error) {
+	chatID, err := c.ParamsInt("id")
+	if err != nil {
+		return nil, "", nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid chat ID"})
+	}
+
+	type Request struct {
+		Message string `json:"message" form:"message"`
+	}
+	var req Request
+	if err := c.BodyParser(&req); err != nil {
+		return nil, "", nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	if err := services.ValidateMessage(req.Message); err != nil {
+		return nil, "", nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	uploads, err := parseUploads(c)
+	if err != nil {
+		return nil, "", nil, attachmentError(c, err)
 	}
 
 	// We need UserID to check limits. Fetch chat first.
 	var chat models.Chat
 	if err := database.DB.First(&chat, chatID).Error; err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Chat not found"})
+		return nil, "", nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Chat not found"})
+	}
+	if err := chatService.CheckAttachments(chat.Provider, chat.ModelName, uploads); err != nil {
+		return nil, "", nil, attachmentError(c, err)
+	}
+	if ok, err := checkQuota(c, chat.UserID, false); !ok {
+		return nil, "", nil, err
 	}
 
-	var previousAssistantMessage models.Message
-	database.DB.Last(&previousAssistantMessage, map[string]interface{}{
-		"chat_id": chatID,
-		"role":    "assistant",
-	})
+	return &chat, req.Message, uploads, nil
+}
+
+func SendMessage(c *fiber.Ctx) error {
+	chat, message, uploads, err := parseMessageRequest(c)
+	if chat == nil {
+		return err
+	}
 
 	// Save User Message
-	userMsg := models.Message{
-		ChatID:  uint(chatID),
-		Role:    "user",
-		Content: req.Message,
+	userMsg, err := chatService.AddUserMessage(chat, message, uploads...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save message"})
 	}
-	database.DB.Create(&userMsg)
-	incrementMessageCount(chat.UserID)
 
-	prompt := req.Message
+	// Get LLM Response
+	ctx, cancel := requestContext(c)
+	assistantMsg, err := chatService.Reply(ctx, chat, userMsg, nil)
+	cancel()
+	if err != nil {
+		return generationError(c, err)
+	}
 
-	var previousId string = previousAssistantMessage.ModelMessageId
+	return c.JSON(assistantMsg)
+}
 
-	// Get LLM Response
-	response, id, err := llmProvider.GenerateResponse(prompt, previousId)
+// writeEvent writes a single server-sent event and flushes it to the client.
+func writeEvent(w *bufio.Writer, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate response"})
+		return err
 	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
 
-	// Save Assistant Message
-	assistantMsg := models.Message{
-		ChatID:         uint(chatID),
-		Role:           "assistant",
-		Content:        response,
-		ModelMessageId: id,
+func StreamMessage(c *fiber.Ctx) error {
+	chat, message, uploads, err := parseMessageRequest(c)
+	if chat == nil {
+		return err
 	}
-	database.DB.Create(&assistantMsg)
 
-	return c.JSON(assistantMsg)
+	// Save User Message
+	userMsg, err := chatService.AddUserMessage(chat, message, uploads...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save message"})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The fiber context is released once the handler returns, so the
+		// stream runs on its own context, cancelled when the client goes away.
+		ctx, cancel := watchConn(context.Background(), conn)
+		defer cancel()
+		assistantMsg, err := chatService.Reply(ctx, chat, userMsg, func(delta string) error {
+			// A failed flush means the client went away, which stops the stream.
+			if err := writeEvent(w, "delta", fiber.Map{"content": delta}); err != nil {
+				cancel()
+				return err
+			}
+			return nil
+		})
+
+		if err != nil {
+			log.Error("Stream failed: ", err)
+			message := "Failed to generate response"
+			if errors.Is(err, llm.ErrSafetyBlocked) || errors.Is(err, llm.ErrUnsupported) {
+				message = err.Error()
+			}
+			// An interrupted stream was still saved; the error carries the
+			// partial answer, flagged as incomplete.
+			if assistantMsg != nil {
+				writeEvent(w, "error", fiber.Map{"error": message, "message": assistantMsg})
+				return
+			}
+			writeEvent(w, "error", fiber.Map{"error": message})
+			return
+		}
+
+		writeEvent(w, "done", assistantMsg)
+	})
+
+	return nil
 }
 
 func Chats(app fiber.Router) {
 	api := app.Group("/chats")
 	api.Post("/", CreateChat)
+	api.Patch("/:id/settings", UpdateChatSettings)
+	api.Put("/:id/branch", SelectBranch)
 	api.Get("/:id/messages", GetMessages)
 	api.Post("/:id/messages", SendMessage)
+	api.Post("/:id/messages/stream", StreamMessage)
 }
@@ -0,0 +1,131 @@
+package v1
+
+import (
+	"context"
+	"errors"
+
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/gofiber/fiber/v2"
+)
+
+// CreateStructuredCompletion generates a JSON value matching the supplied
+// schema, for extraction tasks that don't belong to a chat. It counts
+// against the quotas of the user it is made for.
+func CreateStructuredCompletion(c *fiber.Ctx) error {
+	type Request struct {
+		UserID      uint                   `json:"user_id"`
+		System      string                 `json:"system"`
+		Prompt      string                 `json:"prompt"`
+		Messages    []llm.Message          `json:"messages"`
+		Name        string                 `json:"name"`
+		Schema      map[string]interface{} `json:"schema"`
+		Strict      bool                   `json:"strict"`
+		Model       string                 `json:"model"`
+		Temperature *float64               `json:"temperature"`
+		MaxTokens   int                    `json:"max_tokens"`
+		MaxRepairs  *int                   `json:"max_repairs"`
+	}
+
+	var req Request
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.UserID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id is required"})
+	}
+	if req.Schema == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "schema is required"})
+	}
+
+	for _, message := range req.Messages {
+		if message.Role != "user" && message.Role != "assistant" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "message role must be user or assistant"})
+		}
+		// Images only come in as chat attachments, which are validated.
+		if len(message.Images) > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "images are not accepted, attach them to a chat message"})
+		}
+	}
+
+	messages := req.Messages
+	if req.Prompt != "" {
+		messages = append(messages, llm.Message{Role: "user", Content: req.Prompt})
+	}
+	if len(messages) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "prompt or messages is required"})
+	}
+
+	if err := modelCatalog.Check(req.Model); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if ok, err := checkQuota(c, req.UserID, false); !ok {
+		return err
+	}
+
+	repairs := llm.DefaultStructuredRepairs
+	if req.MaxRepairs != nil && *req.MaxRepairs >= 0 {
+		repairs = *req.MaxRepairs
+	}
+
+	ctx, stop := requestContext(c)
+	defer stop()
+	if chatService.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, chatService.Timeout)
+		defer cancel()
+	}
+
+	resp, err := llm.GenerateStructured(ctx, llmProvider, llm.Request{
+		Model:       req.Model,
+		System:      req.System,
+		Messages:    messages,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		ResponseFormat: &llm.ResponseFormat{
+			Name:   req.Name,
+			Schema: req.Schema,
+			Strict: req.Strict,
+		},
+	}, repairs)
+
+	var schemaErr *llm.SchemaError
+	var validationErr *llm.ValidationError
+	switch {
+	case resp != nil:
+		chatService.RecordUsage(req.UserID, resp.Response)
+	case errors.As(err, &validationErr):
+		// The invalid replies were generated all the same.
+		chatService.RecordUsage(req.UserID, validationErr.Response)
+	}
+
+	switch {
+	case errors.As(err, &schemaErr):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":         "Invalid schema",
+			"schema_errors": schemaErr.Errors,
+		})
+	case errors.As(err, &validationErr):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":             "Response does not match the schema",
+			"validation_errors": validationErr.Errors,
+			"raw":               validationErr.Raw,
+			"attempts":          validationErr.Attempts,
+		})
+	case err != nil:
+		return generationError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"data":     resp.Data,
+		"attempts": resp.Attempts,
+		"model":    resp.Model,
+		"provider": resp.Provider,
+		"usage":    resp.Usage,
+	})
+}
+
+func Completions(app fiber.Router) {
+	api := app.Group("/completions")
+	api.Post("/structured", CreateStructuredCompletion)
+}
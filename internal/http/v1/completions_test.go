@@ -0,0 +1,28 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestStructuredCompletionRejectsImages(t *testing.T) {
+	app := fiber.New()
+	Completions(app)
+
+	body := `{"user_id": 1, "schema": {"type": "object"},
+		"messages": [{"role": "user", "content": "What is this?", "images": [{"mime_type": "image/png", "data": "AAAA"}]}]}`
+	req := httptest.NewRequest(http.MethodPost, "/completions/structured", strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, fiber.StatusBadRequest)
+	}
+}
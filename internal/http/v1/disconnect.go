@@ -0,0 +1,45 @@
+package v1
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestContext is cancelled when the client closes the connection before
+// the handler answers, so an abandoned request stops generating. The handler
+// calls the returned func once it is done with the connection.
+func requestContext(c *fiber.Ctx) (context.Context, context.CancelFunc) {
+	return watchConn(c.Context(), c.Context().Conn())
+}
+
+// watchConn returns a context cancelled when the peer closes conn. Stopping
+// the watch unblocks it through a read deadline, which is cleared again so
+// the server can keep using the connection.
+func watchConn(parent context.Context, conn net.Conn) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	if conn == nil {
+		return ctx, cancel
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if connClosed(conn) {
+			cancel()
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			conn.SetReadDeadline(time.Now())
+			<-done
+			conn.SetReadDeadline(time.Time{})
+			cancel()
+		})
+	}
+}
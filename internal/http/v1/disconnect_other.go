@@ -0,0 +1,11 @@
+//go:build !unix
+
+package v1
+
+import "net"
+
+// connClosed can't peek at connections on this platform, disconnects are
+// only noticed when a write fails.
+func connClosed(conn net.Conn) bool {
+	return false
+}
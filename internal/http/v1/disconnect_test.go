@@ -0,0 +1,86 @@
+package v1
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// connPair returns both ends of a loopback TCP connection.
+func connPair(t *testing.T) (server, client net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return server, client
+}
+
+func TestWatchConnCancelsOnClose(t *testing.T) {
+	server, client := connPair(t)
+	ctx, stop := watchConn(context.Background(), server)
+	defer stop()
+
+	client.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled after the client closed the connection")
+	}
+}
+
+func TestWatchConnStopKeepsConnUsable(t *testing.T) {
+	server, client := connPair(t)
+	ctx, stop := watchConn(context.Background(), server)
+	stop()
+	if ctx.Err() == nil {
+		t.Fatal("context still active after stop")
+	}
+
+	if _, err := client.Write([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := server.Read(buf); err != nil {
+		t.Fatalf("read after stop: %v", err)
+	}
+	if string(buf) != "next" {
+		t.Fatalf("read %q, want %q", buf, "next")
+	}
+}
+
+func TestWatchConnLeavesPipelinedData(t *testing.T) {
+	server, client := connPair(t)
+	ctx, stop := watchConn(context.Background(), server)
+
+	if _, err := client.Write([]byte("GET")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("context cancelled by data from the client")
+	}
+	stop()
+
+	buf := make([]byte, 3)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := server.Read(buf); err != nil || string(buf) != "GET" {
+		t.Fatalf("read %q, %v, want the peeked data", buf, err)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package v1
+
+import (
+	"net"
+	"syscall"
+)
+
+// connClosed waits until conn is readable and reports whether the peer
+// closed it. Data sent meanwhile, like a pipelined request, is only peeked
+// at and left for the server.
+func connClosed(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	closed := false
+	buf := make([]byte, 1)
+	err = raw.Read(func(fd uintptr) bool {
+		n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			return false
+		}
+		closed = n == 0 || err != nil
+		return true
+	})
+	return err == nil && closed
+}
@@ -0,0 +1,17 @@
+package v1
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func GetEmbeddingCacheStats(c *fiber.Ctx) error {
+	if embeddingCache == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Embedding cache disabled"})
+	}
+	return c.JSON(embeddingCache.Stats())
+}
+
+func Embeddings(app fiber.Router) {
+	api := app.Group("/embeddings")
+	api.Get("/cache/stats", GetEmbeddingCacheStats)
+}
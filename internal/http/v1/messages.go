@@ -0,0 +1,105 @@
+package v1
+
+import (
+	"errors"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// parseMessage reads the message in the route and its chat, writing the
+// error response itself when either can't be found.
+func parseMessage(c *fiber.Ctx) (*models.Chat, *models.Message, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid message ID"})
+	}
+
+	message, err := services.GetMessage(uint(id))
+	if errors.Is(err, services.ErrMessageNotFound) {
+		return nil, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Message not found"})
+	}
+	if err != nil {
+		return nil, nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch message"})
+	}
+
+	var chat models.Chat
+	if err := database.DB.First(&chat, message.ChatID).Error; err != nil {
+		return nil, nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Chat not found"})
+	}
+	return &chat, message, nil
+}
+
+// RegenerateMessage answers the user message again, as a sibling of the
+// given reply, and switches the chat to the new branch.
+func RegenerateMessage(c *fiber.Ctx) error {
+	chat, message, err := parseMessage(c)
+	if chat == nil {
+		return err
+	}
+	if ok, err := checkQuota(c, chat.UserID, false); !ok {
+		return err
+	}
+
+	ctx, cancel := requestContext(c)
+	assistantMsg, err := chatService.Regenerate(ctx, chat, message, nil)
+	cancel()
+	if errors.Is(err, services.ErrNoUserMessage) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return generationError(c, err)
+	}
+	return c.JSON(assistantMsg)
+}
+
+// EditMessage forks the chat at a user message with new content, keeping
+// the original branch, and answers the edited message.
+func EditMessage(c *fiber.Ctx) error {
+	chat, message, err := parseMessage(c)
+	if chat == nil {
+		return err
+	}
+
+	type Request struct {
+		Message string `json:"message"`
+	}
+	var req Request
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if message.Role != "user" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": services.ErrNotUserMessage.Error()})
+	}
+	if err := services.ValidateMessage(req.Message); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if ok, err := checkQuota(c, chat.UserID, false); !ok {
+		return err
+	}
+
+	edited, err := chatService.EditMessage(chat, message, req.Message)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save message"})
+	}
+
+	ctx, cancel := requestContext(c)
+	assistantMsg, err := chatService.Reply(ctx, chat, edited, nil)
+	cancel()
+	if err != nil {
+		return generationError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  edited,
+		"response": assistantMsg,
+	})
+}
+
+func Messages(app fiber.Router) {
+	api := app.Group("/messages")
+	api.Put("/:id", EditMessage)
+	api.Post("/:id/regenerate", RegenerateMessage)
+}
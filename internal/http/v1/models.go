@@ -0,0 +1,16 @@
+package v1
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetModels lists the models of every configured backend, as last
+// discovered, with the health of each backend when there are several.
+func GetModels(c *fiber.Ctx) error {
+	return c.JSON(modelCatalog.Snapshot())
+}
+
+func Models(app fiber.Router) {
+	api := app.Group("/models")
+	api.Get("/", GetModels)
+}
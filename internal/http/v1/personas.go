@@ -0,0 +1,129 @@
+package v1
+
+import (
+	"errors"
+
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// parsePersona reads a persona from the request body, writing the error
+// response itself when the body is invalid.
+func parsePersona(c *fiber.Ctx) (*models.Persona, error) {
+	type Request struct {
+		Name         string `json:"name"`
+		Description  string `json:"description"`
+		SystemPrompt string `json:"system_prompt"`
+		Greeting     string `json:"greeting"`
+		llm.Settings
+	}
+
+	var req Request
+	if err := c.BodyParser(&req); err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	return &models.Persona{
+		Name:         req.Name,
+		Description:  req.Description,
+		SystemPrompt: req.SystemPrompt,
+		Greeting:     req.Greeting,
+		Provider:     req.Provider,
+		ModelName:    req.Model,
+		Temperature:  req.Temperature,
+		TopP:         req.TopP,
+		MaxTokens:    req.MaxTokens,
+	}, nil
+}
+
+// personaError turns a persona service failure into the response sent to
+// the client.
+func personaError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrPersonaNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Persona not found"})
+	case errors.Is(err, services.ErrBuiltinPersona):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPersona):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	var invalid *services.InvalidSettingsError
+	if errors.As(err, &invalid) {
+		return settingsError(c, err)
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save persona"})
+}
+
+func GetPersonas(c *fiber.Ctx) error {
+	personas, err := services.Personas()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch personas"})
+	}
+	return c.JSON(personas)
+}
+
+func GetPersona(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid persona ID"})
+	}
+
+	persona, err := services.GetPersona(uint(id))
+	if err != nil {
+		return personaError(c, err)
+	}
+	return c.JSON(persona)
+}
+
+func CreatePersona(c *fiber.Ctx) error {
+	persona, err := parsePersona(c)
+	if persona == nil {
+		return err
+	}
+
+	if err := chatService.CreatePersona(persona); err != nil {
+		return personaError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(persona)
+}
+
+func UpdatePersona(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid persona ID"})
+	}
+
+	update, err := parsePersona(c)
+	if update == nil {
+		return err
+	}
+
+	persona, err := chatService.UpdatePersona(uint(id), *update)
+	if err != nil {
+		return personaError(c, err)
+	}
+	return c.JSON(persona)
+}
+
+func DeletePersona(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid persona ID"})
+	}
+
+	if err := services.DeletePersona(uint(id)); err != nil {
+		return personaError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func Personas(app fiber.Router) {
+	api := app.Group("/personas")
+	api.Get("/", GetPersonas)
+	api.Post("/", CreatePersona)
+	api.Get("/:id", GetPersona)
+	api.Put("/:id", UpdatePersona)
+	api.Delete("/:id", DeletePersona)
+}
@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"errors"
+
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/prompts"
+	"github.com/LDTorres/golang-chat-ai/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// promptError turns a prompt service failure into the response sent to the
+// client.
+func promptError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, services.ErrPromptNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Prompt not found"})
+	}
+	var invalid prompts.Errors
+	if errors.As(err, &invalid) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":         "Invalid prompt",
+			"prompt_errors": invalid,
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to render prompt"})
+}
+
+// GetPrompts lists the latest version of every prompt.
+func GetPrompts(c *fiber.Ctx) error {
+	templates, err := services.LatestPrompts()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch prompts"})
+	}
+	return c.JSON(templates)
+}
+
+// SavePrompt stores a new version of a prompt, which is used from then on.
+func SavePrompt(c *fiber.Ctx) error {
+	type Request struct {
+		Name        string             `json:"name"`
+		Description string             `json:"description"`
+		Body        string             `json:"body"`
+		Variables   []prompts.Variable `json:"variables"`
+	}
+
+	var req Request
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	tmpl := models.PromptTemplate{
+		Name:        req.Name,
+		Description: req.Description,
+		Body:        req.Body,
+		Variables:   req.Variables,
+	}
+	if err := services.SavePrompt(&tmpl); err != nil {
+		var invalid prompts.Errors
+		if errors.As(err, &invalid) {
+			return promptError(c, err)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save prompt"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(tmpl)
+}
+
+// GetPromptVersions lists every version of a prompt, newest first.
+func GetPromptVersions(c *fiber.Ctx) error {
+	versions, err := services.PromptVersions(c.Params("name"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch prompts"})
+	}
+	if len(versions) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Prompt not found"})
+	}
+	return c.JSON(versions)
+}
+
+func GetPromptVersion(c *fiber.Ctx) error {
+	version, err := c.ParamsInt("version")
+	if err != nil || version <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid version"})
+	}
+
+	tmpl, err := services.Prompt(c.Params("name"), version)
+	if err != nil {
+		return promptError(c, err)
+	}
+	return c.JSON(tmpl)
+}
+
+// PreviewPrompt renders a prompt with the given variables without calling
+// the LLM. Version 0 or none renders the latest.
+func PreviewPrompt(c *fiber.Ctx) error {
+	type Request struct {
+		Version   int                    `json:"version"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+
+	var req Request
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	text, tmpl, err := services.RenderPrompt(c.Params("name"), req.Version, req.Variables)
+	if err != nil {
+		return promptError(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"name":    tmpl.Name,
+		"version": tmpl.Version,
+		"prompt":  text,
+	})
+}
+
+func Prompts(app fiber.Router) {
+	api := app.Group("/prompts")
+	api.Get("/", GetPrompts)
+	api.Post("/", SavePrompt)
+	api.Get("/:name", GetPromptVersions)
+	api.Get("/:name/versions/:version", GetPromptVersion)
+	api.Post("/:name/preview", PreviewPrompt)
+}
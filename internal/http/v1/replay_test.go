@@ -0,0 +1,108 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/database/dbtest"
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// postJSON sends body to the app and decodes the answer into out.
+func postJSON(t *testing.T, app *fiber.App, path string, body interface{}, out interface{}) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		var failure map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		t.Fatalf("POST %s: status %d: %v", path, resp.StatusCode, failure)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReplayChat creates a chat and sends a message against the default
+// cassette, so the whole chat flow, down to ChatService.Reply and the
+// Replayer, runs without a model or a network. The database is kept in
+// memory. The cassette was recorded with LLM_RECORD=true from the mock,
+// scripted to answer both messages.
+func TestReplayChat(t *testing.T) {
+	dbtest.Use(t)
+	replayChat(t)
+}
+
+// TestReplayChatPostgres runs the same flow against the Postgres database
+// configured by the DB_* variables, when DB_HOST is set.
+func TestReplayChatPostgres(t *testing.T) {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST is not set")
+	}
+	previous := database.DB
+	database.Connect()
+	t.Cleanup(func() { database.DB = previous })
+	err := database.DB.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}, &models.EmbeddingCache{}, &models.Persona{}, &models.PromptTemplate{}, &models.UsageRecord{}, &models.Attachment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayChat(t)
+}
+
+func replayChat(t *testing.T) {
+	t.Setenv("LLM_PROVIDER", "replay")
+	t.Setenv("LLM_PROVIDERS", "")
+	t.Setenv("LLM_RECORD", "")
+	t.Setenv("LLM_CASSETTE", filepath.Join("..", "..", "..", llm.DefaultCassette))
+	t.Setenv("LLM_TOOLS", "")
+	t.Setenv("EMBEDDING_CACHE", "false")
+	t.Setenv("ATTACHMENTS_DIR", "off")
+
+	app := fiber.New()
+	ApiV1(app)
+	t.Cleanup(CloseLLM)
+
+	var user models.User
+	postJSON(t, app, "/api/v1/users", fiber.Map{
+		"name":  "Replay",
+		"email": fmt.Sprintf("replay-%d@example.com", time.Now().UnixNano()),
+	}, &user)
+
+	var created struct {
+		Chat     models.Chat    `json:"chat"`
+		Response models.Message `json:"response"`
+	}
+	postJSON(t, app, "/api/v1/chats", fiber.Map{"user_id": user.ID, "message": "Hello"}, &created)
+	if created.Response.Content != "Hi! How can I help you today?" {
+		t.Errorf("first reply = %q", created.Response.Content)
+	}
+
+	var reply models.Message
+	postJSON(t, app, fmt.Sprintf("/api/v1/chats/%d/messages", created.Chat.ID), fiber.Map{"message": "How are you?"}, &reply)
+	if reply.Content != "I'm doing well, thanks for asking." {
+		t.Errorf("second reply = %q", reply.Content)
+	}
+	if reply.Provider != "mock" {
+		t.Errorf("provider = %q, want the recorded one", reply.Provider)
+	}
+}
@@ -1,8 +1,12 @@
 package v1
 
 import (
+	"errors"
+	"time"
+
 	"github.com/LDTorres/golang-chat-ai/internal/database"
 	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/services"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
@@ -55,3 +59,64 @@ func CreateUser(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusCreated).JSON(user)
 }
+
+// GetUsage aggregates the user's token usage and cost by day (the default)
+// or month. from and to are dates (YYYY-MM-DD, UTC), both inclusive; they
+// default to the last 30 days or the last 12 months.
+func GetUsage(c *fiber.Ctx) error {
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	period := c.Query("period", services.UsageByDay)
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	to := today.AddDate(0, 0, 1)
+	from := today.AddDate(0, 0, -29)
+	if period == services.UsageByMonth {
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -11, 0)
+	}
+
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse(time.DateOnly, value); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid from date"})
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		day, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid to date"})
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must not be after to"})
+	}
+
+	summary, err := services.UserUsage(uint(userID), period, from, to)
+	if errors.Is(err, services.ErrInvalidUsagePeriod) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch usage"})
+	}
+	return c.JSON(summary)
+}
+
+// GetQuotas reports the user's plan and what is left of each of its limits.
+func GetQuotas(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.DB.First(&user, c.Params("id")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	quotas, err := services.Quotas(&user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch quotas"})
+	}
+	return c.JSON(fiber.Map{
+		"plan":   services.UserPlan(&user),
+		"quotas": quotas,
+	})
+}
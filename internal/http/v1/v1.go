@@ -11,8 +11,37 @@ func ApiV1(app *fiber.App) {
 	// Users
 	v1.Post("/users", CreateUser)
 	v1.Get("/users/:id/chats", GetChats)
+	v1.Get("/users/:id/usage", GetUsage)
+	v1.Get("/users/:id/quotas", GetQuotas)
 
 	// Chats
 	v1.Delete("/chats/:id", DeleteChat) // Register DeleteChat route
 	Chats(v1)
+
+	// Messages
+	Messages(v1)
+
+	// Attachments
+	Attachments(v1)
+
+	// Personas
+	Personas(v1)
+
+	// Prompts
+	Prompts(v1)
+
+	// Models
+	Models(v1)
+
+	// Completions
+	Completions(v1)
+
+	// Embeddings
+	Embeddings(v1)
+
+	// Admin
+	Admin(v1)
+
+	// WebSocket
+	WebSocket(app)
 }
@@ -0,0 +1,376 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/services"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// Frame types exchanged over the chat socket.
+//
+// Client to server: "message", "cancel", "ping", "typing" and "resume".
+// Server to client: "message", "delta", "done", "cancelled", "error", "pong"
+// and "typing".
+type socketFrame struct {
+	Type          string          `json:"type"`
+	Content       string          `json:"content,omitempty"`
+	Message       *models.Message `json:"message,omitempty"`
+	Role          string          `json:"role,omitempty"`
+	Typing        bool            `json:"typing,omitempty"`
+	LastMessageID uint            `json:"last_message_id,omitempty"`
+	Error         string          `json:"error,omitempty"`
+
+	Quota *services.QuotaExceededError `json:"quota,omitempty"` // Why a message was refused
+}
+
+const (
+	socketQueueSize    = 64 // Frames waiting to be written, per client
+	socketWriteTimeout = 10 * time.Second
+)
+
+// socketClient writes frames from its own goroutine, so a slow client never
+// holds up the room that sends to it.
+type socketClient struct {
+	conn    *websocket.Conn
+	queue   chan socketFrame
+	closing chan struct{}
+	stopped chan struct{} // Closed once the writer is done with conn
+	once    sync.Once
+}
+
+func newSocketClient(conn *websocket.Conn) *socketClient {
+	client := &socketClient{
+		conn:    conn,
+		queue:   make(chan socketFrame, socketQueueSize),
+		closing: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go client.write()
+	return client
+}
+
+func (s *socketClient) write() {
+	defer close(s.stopped)
+	for {
+		select {
+		case frame := <-s.queue:
+			s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			if err := s.conn.WriteJSON(frame); err != nil {
+				log.Debug("Socket write failed: ", err)
+				s.close()
+				return
+			}
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+// send queues the frame without waiting. A client whose queue is full can't
+// keep up and is disconnected.
+func (s *socketClient) send(frame socketFrame) {
+	select {
+	case s.queue <- frame:
+	case <-s.closing:
+	default:
+		log.Debug("Socket queue full, closing the connection")
+		s.close()
+	}
+}
+
+// sendWait queues the frame, waiting for room in the queue. It must not be
+// called with a room lock held. It returns false once the client is closed.
+func (s *socketClient) sendWait(frame socketFrame) bool {
+	select {
+	case s.queue <- frame:
+		return true
+	case <-s.closing:
+		return false
+	}
+}
+
+// close stops the writer and closes the connection, which ends the reads of
+// ChatSocket too.
+func (s *socketClient) close() {
+	s.once.Do(func() {
+		close(s.closing)
+		s.conn.Close()
+	})
+}
+
+// chatRoom groups every socket connected to the same chat. Generation belongs
+// to the room rather than to a connection, so a client that reconnects can
+// resume an answer that is still being written.
+type chatRoom struct {
+	mu         sync.Mutex
+	clients    map[*socketClient]struct{}
+	generating bool
+	stop       context.CancelFunc
+	partial    strings.Builder
+}
+
+var rooms = struct {
+	sync.Mutex
+	byChat map[uint]*chatRoom
+}{byChat: map[uint]*chatRoom{}}
+
+func joinRoom(chatID uint, client *socketClient) *chatRoom {
+	rooms.Lock()
+	defer rooms.Unlock()
+
+	room, ok := rooms.byChat[chatID]
+	if !ok {
+		room = &chatRoom{clients: map[*socketClient]struct{}{}}
+		rooms.byChat[chatID] = room
+	}
+
+	room.mu.Lock()
+	room.clients[client] = struct{}{}
+	room.mu.Unlock()
+
+	return room
+}
+
+// releaseRoom drops the room once nobody is connected and nothing is being
+// generated for it.
+func releaseRoom(chatID uint, room *chatRoom) {
+	rooms.Lock()
+	defer rooms.Unlock()
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if len(room.clients) == 0 && !room.generating && rooms.byChat[chatID] == room {
+		delete(rooms.byChat, chatID)
+	}
+}
+
+func (r *chatRoom) leave(chatID uint, client *socketClient) {
+	r.mu.Lock()
+	delete(r.clients, client)
+	r.mu.Unlock()
+
+	releaseRoom(chatID, r)
+}
+
+// broadcast queues the frame for every client in the room except skip. Must
+// be called with r.mu held.
+func (r *chatRoom) broadcast(frame socketFrame, skip *socketClient) {
+	for client := range r.clients {
+		if client != skip {
+			client.send(frame)
+		}
+	}
+}
+
+func (r *chatRoom) publish(frame socketFrame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.broadcast(frame, nil)
+}
+
+// resume replays the messages the client has not seen yet and, when an answer
+// is being generated, the text produced so far.
+func (r *chatRoom) resume(chatID uint, client *socketClient, lastMessageID uint) {
+	messages, err := chatService.History(chatID, lastMessageID)
+	if err != nil {
+		client.send(socketFrame{Type: "error", Error: "Failed to fetch messages"})
+		return
+	}
+
+	// A long history may not fit in the queue, so it is sent outside the
+	// lock.
+	for i := range messages {
+		if !client.sendWait(socketFrame{Type: "message", Message: &messages[i]}) {
+			return
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.generating {
+		client.send(socketFrame{Type: "typing", Role: "assistant", Typing: true})
+		if r.partial.Len() > 0 {
+			client.send(socketFrame{Type: "delta", Content: r.partial.String()})
+		}
+	}
+}
+
+func (r *chatRoom) cancel() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.generating {
+		r.stop()
+	}
+}
+
+// generate persists the user message and generates the assistant answer in the
+// background, streaming it to everyone in the room.
+func (r *chatRoom) generate(chat *models.Chat, client *socketClient, content string) {
+	if err := services.ValidateMessage(content); err != nil {
+		client.send(socketFrame{Type: "error", Error: err.Error()})
+		return
+	}
+	if err := services.CheckQuota(chat.UserID, false); err != nil {
+		var exceeded *services.QuotaExceededError
+		if errors.As(err, &exceeded) {
+			client.send(socketFrame{Type: "error", Error: "Quota exceeded", Quota: exceeded})
+			return
+		}
+		log.Error("Quota check failed: ", err)
+		client.send(socketFrame{Type: "error", Error: "Failed to check quota"})
+		return
+	}
+
+	r.mu.Lock()
+	if r.generating {
+		r.mu.Unlock()
+		client.send(socketFrame{Type: "error", Error: "A response is already being generated"})
+		return
+	}
+	// Generation outlives the connection that started it so reconnecting
+	// clients can resume it; only a cancel frame or the timeout stops it.
+	ctx, stop := context.WithCancel(context.Background())
+	r.generating = true
+	r.stop = stop
+	r.partial.Reset()
+	r.mu.Unlock()
+
+	userMsg, err := chatService.AddUserMessage(chat, content)
+	if err != nil {
+		stop()
+		r.finish(socketFrame{Type: "error", Error: "Failed to save message"})
+		return
+	}
+
+	r.mu.Lock()
+	r.broadcast(socketFrame{Type: "message", Message: userMsg}, nil)
+	r.broadcast(socketFrame{Type: "typing", Role: "assistant", Typing: true}, nil)
+	r.mu.Unlock()
+
+	go func() {
+		defer stop()
+
+		assistantMsg, err := chatService.Reply(ctx, chat, userMsg, func(delta string) error {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			r.partial.WriteString(delta)
+			r.broadcast(socketFrame{Type: "delta", Content: delta}, nil)
+			return nil
+		})
+
+		switch {
+		case errors.Is(err, context.Canceled):
+			r.finish(socketFrame{Type: "cancelled", Message: assistantMsg})
+		case assistantMsg == nil:
+			log.Error("Socket generation failed: ", err)
+			r.finish(socketFrame{Type: "error", Error: "Failed to generate response"})
+		case err != nil:
+			r.finish(socketFrame{Type: "error", Error: "Response interrupted", Message: assistantMsg})
+		default:
+			r.finish(socketFrame{Type: "done", Message: assistantMsg})
+		}
+
+		releaseRoom(chat.ID, r)
+	}()
+}
+
+func (r *chatRoom) finish(frame socketFrame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generating = false
+	r.stop = nil
+	r.partial.Reset()
+	r.broadcast(frame, nil)
+	r.broadcast(socketFrame{Type: "typing", Role: "assistant"}, nil)
+}
+
+func ChatSocket(conn *websocket.Conn) {
+	chat := conn.Locals("chat").(*models.Chat)
+	client := newSocketClient(conn)
+	// The connection is released when ChatSocket returns, the writer must be
+	// done with it by then.
+	defer func() {
+		client.close()
+		<-client.stopped
+	}()
+
+	room := joinRoom(chat.ID, client)
+	defer room.leave(chat.ID, client)
+
+	if lastMessageID, err := strconv.ParseUint(conn.Query("last_message_id"), 10, 64); err == nil {
+		room.resume(chat.ID, client, uint(lastMessageID))
+	}
+
+	for {
+		var frame socketFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Debug("Socket closed: ", err)
+			}
+			return
+		}
+
+		switch frame.Type {
+		case "message":
+			// The settings, persona or branch may have changed since the
+			// socket opened.
+			var current models.Chat
+			if err := database.DB.First(&current, chat.ID).Error; err != nil {
+				client.send(socketFrame{Type: "error", Error: "Chat not found"})
+				continue
+			}
+			room.generate(&current, client, frame.Content)
+		case "cancel":
+			room.cancel()
+		case "ping":
+			client.send(socketFrame{Type: "pong"})
+		case "typing":
+			room.mu.Lock()
+			room.broadcast(socketFrame{Type: "typing", Role: "user", Typing: frame.Typing}, client)
+			room.mu.Unlock()
+		case "resume":
+			room.resume(chat.ID, client, frame.LastMessageID)
+		default:
+			client.send(socketFrame{Type: "error", Error: "Unknown frame type"})
+		}
+	}
+}
+
+// upgradeChatSocket only lets WebSocket upgrades for existing chats through.
+func upgradeChatSocket(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+
+	chatID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid chat ID"})
+	}
+
+	var chat models.Chat
+	if err := database.DB.First(&chat, chatID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Chat not found"})
+	}
+
+	c.Locals("chat", &chat)
+	return c.Next()
+}
+
+func WebSocket(app fiber.Router) {
+	ws := app.Group("/ws")
+	ws.Get("/chats/:id", upgradeChatSocket, websocket.New(ChatSocket))
+}
@@ -0,0 +1,326 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/resilience"
+)
+
+const (
+	anthropicBaseURL          = "https://api.anthropic.com"
+	anthropicVersion          = "2023-06-01"
+	anthropicDefaultMaxTokens = 1024
+)
+
+// AnthropicProvider talks to the Anthropic Messages API. The API keeps no
+// conversation state, so the whole history in Request.Messages is sent on
+// every call.
+type AnthropicProvider struct {
+	ApiKey    string
+	Model     string
+	BaseURL   string
+	MaxTokens int // The Messages API requires max_tokens on every request
+	Client    *http.Client
+}
+
+func NewAnthropicProvider(apiKey string, model string, baseURL string) *AnthropicProvider {
+	if baseURL == "" {
+		baseURL = anthropicBaseURL
+	}
+	return &AnthropicProvider{
+		ApiKey:    apiKey,
+		Model:     model,
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		MaxTokens: anthropicDefaultMaxTokens,
+		Client:    resilience.NewClient("anthropic", resilience.ConfigFromEnv("ANTHROPIC", resilience.DefaultConfig)),
+	}
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      anthropicUsage `json:"usage"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// anthropicFinishReason maps a stop_reason to the provider independent finish
+// reasons.
+func anthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return FinishLength
+	case "refusal":
+		return FinishContentFilter
+	default:
+		return FinishStop
+	}
+}
+
+func (p *AnthropicProvider) newRequest(req Request, stream bool) (anthropicRequest, error) {
+	turns, err := textOnly("anthropic", req.Messages)
+	if err != nil {
+		return anthropicRequest{}, err
+	}
+
+	model := p.Model
+	if req.Model != "" {
+		model = req.Model
+	}
+
+	maxTokens := p.MaxTokens
+	if req.MaxTokens > 0 {
+		maxTokens = req.MaxTokens
+	}
+
+	// System turns go to the top level system field. The API expects user and
+	// assistant turns to alternate, starting with a user turn, so consecutive
+	// turns of the same role are merged and assistant turns before the first
+	// user turn, like an answer whose question fell out of the memory window,
+	// are dropped.
+	system := []string{}
+	if prompt := systemPrompt(req); prompt != "" {
+		system = append(system, prompt)
+	}
+	messages := make([]anthropicMessage, 0, len(req.Messages))
+	for _, message := range withoutTools(turns) {
+		if message.Role == "system" {
+			system = append(system, message.Content)
+			continue
+		}
+		if len(messages) == 0 && message.Role != "user" {
+			continue
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == message.Role {
+			messages[n-1].Content += "\n\n" + message.Content
+			continue
+		}
+		messages = append(messages, anthropicMessage{Role: message.Role, Content: message.Content})
+	}
+
+	return anthropicRequest{
+		Model:         model,
+		System:        strings.Join(system, "\n\n"),
+		Messages:      messages,
+		MaxTokens:     maxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+		Stream:        stream,
+	}, nil
+}
+
+// send posts a Messages API request. The caller is responsible for closing
+// the response body.
+func (p *AnthropicProvider) send(ctx context.Context, body anthropicRequest) (*http.Response, error) {
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.BaseURL+"/v1/messages", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", p.ApiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, anthropicAPIError(resp)
+	}
+
+	return resp, nil
+}
+
+func anthropicAPIError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		Error anthropicError `json:"error"`
+	}
+	apiErr := &APIError{Provider: "anthropic", StatusCode: resp.StatusCode, Message: string(body)}
+	if err := json.Unmarshal(body, &result); err == nil && result.Error.Message != "" {
+		apiErr.Type = result.Error.Type
+		apiErr.Message = result.Error.Message
+	}
+	return apiErr
+}
+
+func (p *AnthropicProvider) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	body, err := p.newRequest(req, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.send(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	return &Response{
+		Text:         text.String(),
+		ID:           result.ID,
+		FinishReason: anthropicFinishReason(result.StopReason),
+		Model:        result.Model,
+		Usage: Usage{
+			PromptTokens:     result.Usage.InputTokens,
+			CompletionTokens: result.Usage.OutputTokens,
+			TotalTokens:      result.Usage.InputTokens + result.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+func (p *AnthropicProvider) StreamResponse(ctx context.Context, req Request, onDelta func(delta string) error) (*Response, error) {
+	body, err := p.newRequest(req, true)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.send(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	partial := &Response{}
+	var text strings.Builder
+
+	err = readEvents(resp.Body, func(event string, data string) error {
+		var payload struct {
+			Message anthropicResponse `json:"message"`
+			Delta   struct {
+				Type       string `json:"type"`
+				Text       string `json:"text"`
+				StopReason string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage anthropicUsage `json:"usage"`
+			Error anthropicError `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &payload); err != nil {
+			return err
+		}
+
+		switch event {
+		case "message_start":
+			partial.ID = payload.Message.ID
+			partial.Model = payload.Message.Model
+			partial.Usage.PromptTokens = payload.Message.Usage.InputTokens
+		case "content_block_delta":
+			if payload.Delta.Type != "text_delta" {
+				return nil
+			}
+			text.WriteString(payload.Delta.Text)
+			return onDelta(payload.Delta.Text)
+		case "message_delta":
+			partial.FinishReason = anthropicFinishReason(payload.Delta.StopReason)
+			partial.Usage.CompletionTokens = payload.Usage.OutputTokens
+		case "message_stop":
+			return io.EOF
+		case "error":
+			// Errors after the stream started arrive as events, not statuses.
+			return &APIError{Provider: "anthropic", StatusCode: anthropicErrorStatus(payload.Error.Type), Type: payload.Error.Type, Message: payload.Error.Message}
+		}
+		return nil
+	})
+
+	partial.Text = text.String()
+	partial.Usage.TotalTokens = partial.Usage.PromptTokens + partial.Usage.CompletionTokens
+	if err != nil {
+		return partial, err
+	}
+
+	return partial, nil
+}
+
+// anthropicErrorStatus returns the HTTP status matching an error type, for
+// errors reported inside a stream.
+func anthropicErrorStatus(errorType string) int {
+	switch errorType {
+	case "invalid_request_error":
+		return http.StatusBadRequest
+	case "authentication_error":
+		return http.StatusUnauthorized
+	case "permission_error":
+		return http.StatusForbidden
+	case "not_found_error":
+		return http.StatusNotFound
+	case "request_too_large":
+		return http.StatusRequestEntityTooLarge
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "overloaded_error":
+		return 529
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (p *AnthropicProvider) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	return nil, fmt.Errorf("anthropic embeddings: %w", ErrUnsupported)
+}
+
+func (p *AnthropicProvider) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, fmt.Errorf("anthropic embeddings: %w", ErrUnsupported)
+}
+
+func (p *AnthropicProvider) DefaultModel() string {
+	return p.Model
+}
+
+func (p *AnthropicProvider) EmbeddingModelID() string {
+	return ""
+}
@@ -0,0 +1,235 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// anthropicServer answers every Messages API call with status and body and
+// records the decoded request.
+func anthropicServer(t *testing.T, status int, contentType string, body string) (*AnthropicProvider, *anthropicRequest) {
+	t.Helper()
+	var got anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("path = %s, want /v1/messages", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "key" || r.Header.Get("anthropic-version") != anthropicVersion {
+			t.Errorf("missing auth headers: %v", r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewAnthropicProvider("key", "claude-test", server.URL)
+	provider.Client = server.Client()
+	return provider, &got
+}
+
+func TestAnthropicRequestMapping(t *testing.T) {
+	temperature := 0.5
+	tests := []struct {
+		name string
+		req  Request
+		want anthropicRequest
+	}{
+		{
+			name: "defaults",
+			req:  Request{Messages: []Message{{Role: "user", Content: "hi"}}},
+			want: anthropicRequest{
+				Model:     "claude-test",
+				Messages:  []anthropicMessage{{Role: "user", Content: "hi"}},
+				MaxTokens: anthropicDefaultMaxTokens,
+			},
+		},
+		{
+			name: "overrides",
+			req: Request{
+				Model:       "claude-other",
+				Messages:    []Message{{Role: "user", Content: "hi"}},
+				MaxTokens:   10,
+				Temperature: &temperature,
+				Stop:        []string{"END"},
+			},
+			want: anthropicRequest{
+				Model:         "claude-other",
+				Messages:      []anthropicMessage{{Role: "user", Content: "hi"}},
+				MaxTokens:     10,
+				Temperature:   &temperature,
+				StopSequences: []string{"END"},
+			},
+		},
+		{
+			name: "system turns and merged roles",
+			req: Request{
+				System: "be brief",
+				Messages: []Message{
+					{Role: "system", Content: "summary"},
+					{Role: "user", Content: "a"},
+					{Role: "user", Content: "b"},
+					{Role: "assistant", Content: "c"},
+				},
+			},
+			want: anthropicRequest{
+				Model:     "claude-test",
+				System:    "be brief\n\nsummary",
+				Messages:  []anthropicMessage{{Role: "user", Content: "a\n\nb"}, {Role: "assistant", Content: "c"}},
+				MaxTokens: anthropicDefaultMaxTokens,
+			},
+		},
+		{
+			name: "leading assistant turns",
+			req: Request{Messages: []Message{
+				{Role: "assistant", Content: "old answer"},
+				{Role: "user", Content: "hi"},
+			}},
+			want: anthropicRequest{
+				Model:     "claude-test",
+				Messages:  []anthropicMessage{{Role: "user", Content: "hi"}},
+				MaxTokens: anthropicDefaultMaxTokens,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, got := anthropicServer(t, http.StatusOK, "application/json", `{"content":[{"type":"text","text":"ok"}]}`)
+			if _, err := provider.GenerateResponse(context.Background(), tt.req); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("request = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicGenerateResponse(t *testing.T) {
+	provider, _ := anthropicServer(t, http.StatusOK, "application/json", `{
+		"id": "msg_1",
+		"model": "claude-test",
+		"content": [{"type": "text", "text": "Hello"}, {"type": "text", "text": " there"}],
+		"stop_reason": "max_tokens",
+		"usage": {"input_tokens": 3, "output_tokens": 2}
+	}`)
+
+	resp, err := provider.GenerateResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Response{
+		Text:         "Hello there",
+		ID:           "msg_1",
+		FinishReason: FinishLength,
+		Model:        "claude-test",
+		Usage:        Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestAnthropicStreamResponse(t *testing.T) {
+	events := []string{
+		`event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","model":"claude-test","usage":{"input_tokens":3}}}`,
+		`event: content_block_delta
+data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"Hel"}}`,
+		`event: ping
+data: {"type":"ping"}`,
+		`event: content_block_delta
+data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"lo"}}`,
+		`event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":2}}`,
+		`event: message_stop
+data: {"type":"message_stop"}`,
+	}
+	provider, got := anthropicServer(t, http.StatusOK, "text/event-stream", strings.Join(events, "\n\n")+"\n\n")
+
+	var deltas []string
+	resp, err := provider.StreamResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Stream {
+		t.Error("stream not requested")
+	}
+	if !reflect.DeepEqual(deltas, []string{"Hel", "lo"}) {
+		t.Errorf("deltas = %q", deltas)
+	}
+	want := &Response{
+		Text:         "Hello",
+		ID:           "msg_1",
+		FinishReason: FinishStop,
+		Model:        "claude-test",
+		Usage:        Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		stream bool
+		want   error
+		typ    string
+	}{
+		{"invalid request", http.StatusBadRequest, `{"error":{"type":"invalid_request_error","message":"bad"}}`, false, ErrInvalidRequest, "invalid_request_error"},
+		{"authentication", http.StatusUnauthorized, `{"error":{"type":"authentication_error","message":"no key"}}`, false, ErrAuthentication, "authentication_error"},
+		{"rate limited", http.StatusTooManyRequests, `{"error":{"type":"rate_limit_error","message":"slow down"}}`, false, ErrRateLimited, "rate_limit_error"},
+		{"overloaded", 529, `{"error":{"type":"overloaded_error","message":"busy"}}`, false, ErrOverloaded, "overloaded_error"},
+		{"plain body", http.StatusInternalServerError, `oops`, false, ErrProviderFailure, ""},
+		{"stream error event", http.StatusOK, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"busy\"}}\n\n", true, ErrOverloaded, "overloaded_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, _ := anthropicServer(t, tt.status, "application/json", tt.body)
+			req := Request{Messages: []Message{{Role: "user", Content: "hi"}}}
+
+			var err error
+			if tt.stream {
+				_, err = provider.StreamResponse(context.Background(), req, func(string) error { return nil })
+			} else {
+				_, err = provider.GenerateResponse(context.Background(), req)
+			}
+
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.Type != tt.typ {
+				t.Errorf("error = %#v, want type %q", err, tt.typ)
+			}
+		})
+	}
+}
+
+func TestAnthropicRejectsImages(t *testing.T) {
+	provider := NewAnthropicProvider("key", "claude-test", "http://127.0.0.1:0")
+	_, err := provider.GenerateResponse(context.Background(), Request{Messages: []Message{
+		{Role: "user", Content: "what is this?", Images: []Image{{MIMEType: "image/png", Data: []byte("png")}}},
+	}})
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("error = %v, want %v", err, ErrUnsupported)
+	}
+}
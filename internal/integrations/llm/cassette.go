@@ -0,0 +1,408 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// DefaultCassette is where LLM_RECORD writes and LLM_PROVIDER=replay reads
+// when LLM_CASSETTE isn't set.
+const DefaultCassette = "testdata/cassettes/default.json"
+
+func cassettePath() string {
+	if path := os.Getenv("LLM_CASSETTE"); path != "" {
+		return path
+	}
+	return DefaultCassette
+}
+
+// ErrCassetteMiss is returned by Replayer for a call that wasn't recorded.
+var ErrCassetteMiss = errors.New("no recorded interaction for request")
+
+// Interaction kinds.
+const (
+	interactionGenerate   = "generate"
+	interactionStream     = "stream"
+	interactionEmbedding  = "embedding"
+	interactionEmbeddings = "embeddings"
+)
+
+// Cassette is a recording of the calls made to a provider, stored as JSON.
+type Cassette struct {
+	DefaultModel     string        `json:"default_model"`
+	EmbeddingModelID string        `json:"embedding_model_id"`
+	Interactions     []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded call. Key identifies the request, so replays
+// only depend on what was asked, not on when.
+type Interaction struct {
+	Kind       string          `json:"kind"`
+	Key        string          `json:"key"`
+	Request    json.RawMessage `json:"request"`
+	Response   *Response       `json:"response,omitempty"`
+	Deltas     []string        `json:"deltas,omitempty"` // Stream chunks, in order
+	Embeddings [][]float32     `json:"embeddings,omitempty"`
+	Error      *recordedError  `json:"error,omitempty"`
+}
+
+// recordedError keeps the sentinel a recorded error wrapped, so a replayed
+// error still matches errors.Is.
+type recordedError struct {
+	Message  string `json:"message"`
+	Sentinel string `json:"sentinel,omitempty"`
+}
+
+// sentinels are checked in order, the first match is recorded.
+var sentinels = []struct {
+	name string
+	err  error
+}{
+	{"canceled", context.Canceled},
+	{"deadline_exceeded", context.DeadlineExceeded},
+	{"safety_blocked", ErrSafetyBlocked},
+	{"model_unavailable", ErrModelUnavailable},
+	{"unsupported", ErrUnsupported},
+	{"invalid_request", ErrInvalidRequest},
+	{"authentication", ErrAuthentication},
+	{"permission", ErrPermission},
+	{"not_found", ErrNotFound},
+	{"rate_limited", ErrRateLimited},
+	{"overloaded", ErrOverloaded},
+	{"provider_failure", ErrProviderFailure},
+}
+
+func recordError(err error) *recordedError {
+	if err == nil {
+		return nil
+	}
+	recorded := &recordedError{Message: err.Error()}
+	for _, sentinel := range sentinels {
+		if errors.Is(err, sentinel.err) {
+			recorded.Sentinel = sentinel.name
+			break
+		}
+	}
+	return recorded
+}
+
+func (e *recordedError) err() error {
+	if e == nil {
+		return nil
+	}
+	for _, sentinel := range sentinels {
+		if sentinel.name == e.Sentinel {
+			return &replayedError{message: e.Message, sentinel: sentinel.err}
+		}
+	}
+	return errors.New(e.Message)
+}
+
+type replayedError struct {
+	message  string
+	sentinel error
+}
+
+func (e *replayedError) Error() string { return e.message }
+func (e *replayedError) Unwrap() error { return e.sentinel }
+
+// interactionKey hashes the kind and request, which must marshal the same way
+// every time.
+func interactionKey(kind string, request json.RawMessage) string {
+	sum := sha256.Sum256(append([]byte(kind+"\n"), request...))
+	return hex.EncodeToString(sum[:])
+}
+
+func newInteraction(kind string, request interface{}) (Interaction, error) {
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return Interaction{}, err
+	}
+	return Interaction{Kind: kind, Key: interactionKey(kind, raw), Request: raw}, nil
+}
+
+// LoadCassette reads a cassette file.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	return &cassette, nil
+}
+
+// Save writes the cassette to path, creating its directory. The file is
+// replaced atomically so a crash never leaves half a cassette.
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Recorder is an LLMProvider that passes every call on to Provider and
+// records it, errors included, into the cassette at Path. Calls are kept in
+// memory and the cassette is written by Flush or Close.
+type Recorder struct {
+	Provider LLMProvider
+	Path     string
+
+	mu       sync.Mutex
+	cassette Cassette
+	dirty    bool // Calls recorded since the last Flush
+}
+
+// NewRecorder records the calls made to provider into a new cassette at path.
+func NewRecorder(provider LLMProvider, path string) *Recorder {
+	return &Recorder{
+		Provider: provider,
+		Path:     path,
+		cassette: Cassette{
+			DefaultModel:     provider.DefaultModel(),
+			EmbeddingModelID: provider.EmbeddingModelID(),
+			Interactions:     []Interaction{},
+		},
+	}
+}
+
+// Unwrap returns the recorded provider, so the Catalog sees its backends.
+func (r *Recorder) Unwrap() LLMProvider {
+	return r.Provider
+}
+
+func (r *Recorder) record(interaction Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.dirty = true
+}
+
+// Flush writes the calls recorded so far to the cassette.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.dirty {
+		return nil
+	}
+	if err := r.cassette.Save(r.Path); err != nil {
+		return fmt.Errorf("saving cassette %s: %w", r.Path, err)
+	}
+	r.dirty = false
+	return nil
+}
+
+// Close writes the cassette. The Recorder can keep recording afterwards.
+func (r *Recorder) Close() error {
+	return r.Flush()
+}
+
+func (r *Recorder) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	interaction, err := newInteraction(interactionGenerate, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.Provider.GenerateResponse(ctx, req)
+	interaction.Response = resp
+	interaction.Error = recordError(err)
+	r.record(interaction)
+	return resp, err
+}
+
+func (r *Recorder) StreamResponse(ctx context.Context, req Request, onDelta func(delta string) error) (*Response, error) {
+	interaction, err := newInteraction(interactionStream, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.Provider.StreamResponse(ctx, req, func(delta string) error {
+		interaction.Deltas = append(interaction.Deltas, delta)
+		return onDelta(delta)
+	})
+	interaction.Response = resp
+	interaction.Error = recordError(err)
+	r.record(interaction)
+	return resp, err
+}
+
+func (r *Recorder) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	interaction, err := newInteraction(interactionEmbedding, text)
+	if err != nil {
+		return nil, err
+	}
+
+	embedding, err := r.Provider.GenerateEmbedding(ctx, text)
+	if embedding != nil {
+		interaction.Embeddings = [][]float32{embedding}
+	}
+	interaction.Error = recordError(err)
+	r.record(interaction)
+	return embedding, err
+}
+
+func (r *Recorder) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	interaction, err := newInteraction(interactionEmbeddings, texts)
+	if err != nil {
+		return nil, err
+	}
+
+	embeddings, err := r.Provider.GenerateEmbeddings(ctx, texts)
+	interaction.Embeddings = embeddings
+	interaction.Error = recordError(err)
+	r.record(interaction)
+	return embeddings, err
+}
+
+func (r *Recorder) EmbeddingModelID() string {
+	return r.Provider.EmbeddingModelID()
+}
+
+func (r *Recorder) DefaultModel() string {
+	return r.Provider.DefaultModel()
+}
+
+// Replayer is an LLMProvider that answers from a cassette instead of a
+// backend. Interactions for the same request are served in the order they
+// were recorded, the last one repeating. A request that was never recorded
+// fails with ErrCassetteMiss.
+type Replayer struct {
+	cassette *Cassette
+
+	mu     sync.Mutex
+	served map[string]int // Interactions served so far, by key
+}
+
+// NewReplayer replays the cassette at path.
+func NewReplayer(path string) (*Replayer, error) {
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{cassette: cassette, served: map[string]int{}}, nil
+}
+
+// next returns the interaction to replay for the request.
+func (r *Replayer) next(kind string, request interface{}) (*Interaction, error) {
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	key := interactionKey(kind, raw)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matches []*Interaction
+	for i := range r.cassette.Interactions {
+		if r.cassette.Interactions[i].Key == key {
+			matches = append(matches, &r.cassette.Interactions[i])
+		}
+	}
+	if len(matches) == 0 {
+		log.Error("Cassette miss for ", kind, " request: ", string(raw))
+		return nil, fmt.Errorf("%w: %s %s", ErrCassetteMiss, kind, raw)
+	}
+
+	served := r.served[key]
+	r.served[key] = served + 1
+	return matches[min(served, len(matches)-1)], nil
+}
+
+// response copies the recorded response, so callers can't change the
+// cassette.
+func (i *Interaction) response() *Response {
+	if i.Response == nil {
+		return nil
+	}
+	resp := *i.Response
+	resp.ToolCalls = slices.Clone(resp.ToolCalls)
+	resp.Failovers = slices.Clone(resp.Failovers)
+	return &resp
+}
+
+func (r *Replayer) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	interaction, err := r.next(interactionGenerate, req)
+	if err != nil {
+		return nil, err
+	}
+	return interaction.response(), interaction.Error.err()
+}
+
+func (r *Replayer) StreamResponse(ctx context.Context, req Request, onDelta func(delta string) error) (*Response, error) {
+	interaction, err := r.next(interactionStream, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := interaction.response()
+	for _, delta := range interaction.Deltas {
+		if err := ctx.Err(); err != nil {
+			return resp, err
+		}
+		if err := onDelta(delta); err != nil {
+			return resp, err
+		}
+	}
+	return resp, interaction.Error.err()
+}
+
+func (r *Replayer) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	interaction, err := r.next(interactionEmbedding, text)
+	if err != nil {
+		return nil, err
+	}
+	return firstEmbedding(interaction.Embeddings, interaction.Error.err())
+}
+
+func (r *Replayer) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	interaction, err := r.next(interactionEmbeddings, texts)
+	if err != nil {
+		return nil, err
+	}
+	return interaction.Embeddings, interaction.Error.err()
+}
+
+func (r *Replayer) EmbeddingModelID() string {
+	return r.cassette.EmbeddingModelID
+}
+
+func (r *Replayer) DefaultModel() string {
+	return r.cassette.DefaultModel
+}
+
+// GetModels lists the models that answered in the cassette.
+func (r *Replayer) GetModels(ctx context.Context) ([]string, error) {
+	models := []string{}
+	if r.cassette.DefaultModel != "" {
+		models = append(models, r.cassette.DefaultModel)
+	}
+	for _, interaction := range r.cassette.Interactions {
+		if interaction.Response != nil && interaction.Response.Model != "" && !slices.Contains(models, interaction.Response.Model) {
+			models = append(models, interaction.Response.Model)
+		}
+	}
+	return models, nil
+}
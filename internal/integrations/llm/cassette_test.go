@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecorderWritesOnFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassettes", "test.json")
+	recorder := NewRecorder(&MockLLM{}, path)
+	req := Request{Messages: []Message{{Role: "user", Content: "hi"}}}
+
+	want, err := recorder.GenerateResponse(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("cassette written before Flush: %v", err)
+	}
+
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := replayer.GenerateResponse(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Text != want.Text {
+		t.Errorf("replayed %q, want %q", got.Text, want.Text)
+	}
+}
+
+// TestReplayDefaultCassette replays the conversation recorded in the default
+// cassette, which backs the app's replay tests.
+func TestReplayDefaultCassette(t *testing.T) {
+	replayer, err := NewReplayer(filepath.Join("..", "..", "..", DefaultCassette))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hello := Message{Role: "user", Content: "Hello"}
+	first, err := replayer.GenerateResponse(context.Background(), Request{Messages: []Message{hello}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Text != "Hi! How can I help you today?" {
+		t.Errorf("first reply = %q", first.Text)
+	}
+
+	second, err := replayer.GenerateResponse(context.Background(), Request{
+		Messages: []Message{
+			hello,
+			{Role: "assistant", Content: first.Text},
+			{Role: "user", Content: "How are you?"},
+		},
+		PreviousProvider: first.Provider,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Text != "I'm doing well, thanks for asking." {
+		t.Errorf("second reply = %q", second.Text)
+	}
+
+	_, err = replayer.GenerateResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "Unrecorded"}}})
+	if !errors.Is(err, ErrCassetteMiss) {
+		t.Errorf("error = %v, want %v", err, ErrCassetteMiss)
+	}
+}
@@ -0,0 +1,420 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/tokenizer"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// ModelLister is implemented by providers that can list their models.
+type ModelLister interface {
+	GetModels(ctx context.Context) ([]string, error)
+}
+
+// ModelInfo describes a model available on a backend. Capabilities are
+// inferred from the provider and the model name, context windows from the
+// tokenizer registry.
+type ModelInfo struct {
+	ID            string `json:"id"`
+	Provider      string `json:"provider"`
+	Chat          bool   `json:"chat"`
+	Embeddings    bool   `json:"embeddings"`
+	Streaming     bool   `json:"streaming"`
+	Tools         bool   `json:"tools"`
+	Vision        bool   `json:"vision"` // Reads images attached to user turns
+	ContextWindow int    `json:"context_window"`
+	// Discovered is false for models that are only known from the
+	// configuration, because the provider can't list its models.
+	Discovered bool `json:"discovered"`
+}
+
+type catalogBackend struct {
+	name     string
+	provider LLMProvider
+	models   []ModelInfo
+	listed   bool // models come from a successful discovery
+	err      error
+}
+
+// Catalog keeps the models of every configured backend, discovered once and
+// refreshed in the background, so requests can be checked without asking
+// the backend each time.
+type Catalog struct {
+	mu        sync.RWMutex
+	backends  []*catalogBackend
+	router    *Router // Reports the backends' health, nil for a single provider
+	updatedAt time.Time
+}
+
+// CatalogSnapshot is the catalog as served by the API.
+type CatalogSnapshot struct {
+	Models    []ModelInfo       `json:"models"`
+	Errors    map[string]string `json:"errors,omitempty"` // Failed discoveries, by backend
+	Backends  []BackendHealth   `json:"backends,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// NewCatalog builds a catalog for provider, with one entry per backend when
+// it's a Router. The Router then uses the catalog to know which backends
+// serve the model of a request.
+func NewCatalog(provider LLMProvider) *Catalog {
+	if wrapper, ok := provider.(interface{ Unwrap() LLMProvider }); ok {
+		provider = wrapper.Unwrap()
+	}
+
+	catalog := &Catalog{}
+	if router, ok := provider.(*Router); ok {
+		for _, backend := range router.Backends {
+			catalog.backends = append(catalog.backends, &catalogBackend{name: backend.Name, provider: backend.Provider})
+		}
+		catalog.router = router
+		router.Models = catalog
+	} else {
+		catalog.backends = []*catalogBackend{{name: "default", provider: provider}}
+	}
+
+	for _, backend := range catalog.backends {
+		backend.models = configuredModels(backend.name, backend.provider)
+	}
+	return catalog
+}
+
+// Start refreshes the catalog now and then every interval, until the
+// returned function is called.
+func (c *Catalog) Start(interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			c.Refresh(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cancel
+}
+
+// Refresh lists the models of every backend. A backend that fails keeps the
+// models it had.
+func (c *Catalog) Refresh(ctx context.Context) {
+	for _, backend := range c.backends {
+		lister, ok := backend.provider.(ModelLister)
+		if !ok {
+			continue
+		}
+
+		listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		ids, err := lister.GetModels(listCtx)
+		cancel()
+
+		c.mu.Lock()
+		backend.err = err
+		if err == nil {
+			backend.listed = true
+			backend.models = make([]ModelInfo, len(ids))
+			for i, id := range ids {
+				backend.models[i] = describeModel(backend.name, backend.provider, id, true)
+			}
+		}
+		c.mu.Unlock()
+
+		if err != nil {
+			log.Warn("Model discovery failed for ", backend.name, ": ", err)
+		}
+	}
+
+	c.mu.Lock()
+	c.updatedAt = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *Catalog) Snapshot() CatalogSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snapshot := CatalogSnapshot{Models: []ModelInfo{}, UpdatedAt: c.updatedAt}
+	if c.router != nil {
+		snapshot.Backends = c.router.Health()
+	}
+	for _, backend := range c.backends {
+		snapshot.Models = append(snapshot.Models, backend.models...)
+		if backend.err != nil {
+			if snapshot.Errors == nil {
+				snapshot.Errors = map[string]string{}
+			}
+			snapshot.Errors[backend.name] = backend.err.Error()
+		}
+	}
+	return snapshot
+}
+
+// Lookup returns the model with the given ID on any backend.
+func (c *Catalog) Lookup(model string) (ModelInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, backend := range c.backends {
+		for _, info := range backend.models {
+			if matchModel(info.ID, model) {
+				return info, true
+			}
+		}
+	}
+	return ModelInfo{}, false
+}
+
+// Serves tells whether the named backend has model. It's only known for
+// backends that listed their models, or when model is one they are
+// configured with.
+func (c *Catalog) Serves(backend string, model string) (serves bool, known bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, candidate := range c.backends {
+		if candidate.name != backend {
+			continue
+		}
+		for _, info := range candidate.models {
+			if matchModel(info.ID, model) {
+				return true, true
+			}
+		}
+		return false, candidate.listed
+	}
+	return false, false
+}
+
+// SupportsVision tells whether model reads images, on the named backend or
+// on any backend when provider is empty. Models the catalog doesn't know are
+// assumed to; the provider still refuses images it can't read.
+func (c *Catalog) SupportsVision(provider string, model string) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, backend := range c.backends {
+		if provider != "" && backend.name != provider {
+			continue
+		}
+		for _, info := range backend.models {
+			if matchModel(info.ID, model) {
+				return info.Vision
+			}
+		}
+	}
+	return true
+}
+
+// Check returns ErrModelUnavailable when model is known to be missing: every
+// backend listed its models and none has it. Backends that can't be listed
+// might serve any model, so they never fail the check.
+func (c *Catalog) Check(model string) error {
+	if c == nil || model == "" {
+		return nil
+	}
+	if _, ok := c.Lookup(model); ok {
+		return nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, backend := range c.backends {
+		if !backend.listed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: model %s not found", ErrModelUnavailable, model)
+}
+
+// configuredModels are the models known before discovery: the chat and
+// embedding models the backend is configured with.
+func configuredModels(name string, provider LLMProvider) []ModelInfo {
+	var models []ModelInfo
+	if model := provider.DefaultModel(); model != "" {
+		models = append(models, describeModel(name, provider, model, false))
+	}
+	embedding, _, _ := strings.Cut(provider.EmbeddingModelID(), "@")
+	if embedding != "" && embedding != provider.DefaultModel() {
+		models = append(models, describeModel(name, provider, embedding, false))
+	}
+	return models
+}
+
+func describeModel(name string, provider LLMProvider, id string, discovered bool) ModelInfo {
+	embedding, _, _ := strings.Cut(provider.EmbeddingModelID(), "@")
+	embeddings := matchModel(id, embedding) || strings.Contains(strings.ToLower(id), "embed")
+
+	tools, vision := false, false
+	switch provider.(type) {
+	case *OpenAIProvider, *LmStudioProvider:
+		tools = !embeddings
+		vision = !embeddings && visionModel(id)
+	case *MockLLM, *Replayer:
+		tools = !embeddings
+		vision = !embeddings
+	}
+
+	return ModelInfo{
+		ID:            id,
+		Provider:      name,
+		Chat:          !embeddings,
+		Embeddings:    embeddings,
+		Streaming:     !embeddings,
+		Tools:         tools,
+		Vision:        vision,
+		ContextWindow: tokenizer.LookupModel(id).ContextWindow,
+		Discovered:    discovered,
+	}
+}
+
+// Models that read images, by name: OpenAI families by prefix and local
+// models by the markers their names usually carry. Any other model is
+// taken to be text only.
+var (
+	visionModelPrefixes = []string{"gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4-turbo", "gpt-4-vision", "gpt-5", "o1", "o3", "o4"}
+	visionModelMarkers  = []string{"llava", "vision", "-vl", "pixtral", "moondream", "minicpm-v"}
+	textModelPrefixes   = []string{"gpt-4-turbo-preview", "o1-mini", "o1-preview", "o3-mini"}
+)
+
+// visionModel tells whether the model named id reads images. The publisher
+// LM Studio puts before the name, as in "openai/gpt-4o", is ignored.
+func visionModel(id string) bool {
+	name := strings.ToLower(id)
+	name = name[strings.LastIndex(name, "/")+1:]
+
+	hasPrefix := func(prefix string) bool { return strings.HasPrefix(name, prefix) }
+	if slices.ContainsFunc(textModelPrefixes, hasPrefix) {
+		return false
+	}
+	if slices.ContainsFunc(visionModelPrefixes, hasPrefix) {
+		return true
+	}
+	return slices.ContainsFunc(visionModelMarkers, func(marker string) bool { return strings.Contains(name, marker) })
+}
+
+// matchModel compares model names, treating a missing Ollama tag as
+// "latest".
+func matchModel(a, b string) bool {
+	return a == b || sameModel(a, b) || sameModel(b, a)
+}
+
+// Settings are the generation parameters a chat can choose. Empty fields
+// keep the backend defaults.
+type Settings struct {
+	Provider    string   `json:"provider" form:"provider"`
+	Model       string   `json:"model" form:"model"`
+	Temperature *float64 `json:"temperature" form:"temperature"`
+	TopP        *float64 `json:"top_p" form:"top_p"`
+	MaxTokens   int      `json:"max_tokens" form:"max_tokens"`
+}
+
+// SettingsError is a setting the backend doesn't support.
+type SettingsError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidateSettings checks settings against the catalog and returns them
+// with the backend filled in when the model identifies it, so the Router
+// doesn't send the model to backends that don't serve it.
+func (c *Catalog) ValidateSettings(settings Settings) (Settings, []SettingsError) {
+	var errs []SettingsError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, SettingsError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var backend *catalogBackend
+	if settings.Provider != "" {
+		for _, candidate := range c.backends {
+			if candidate.name == settings.Provider {
+				backend = candidate
+			}
+		}
+		if backend == nil {
+			add("provider", "unknown provider %q", settings.Provider)
+			return settings, errs
+		}
+	}
+
+	model := settings.Model
+	if model != "" {
+		var found *ModelInfo
+		for _, candidate := range c.backends {
+			if backend != nil && candidate != backend {
+				continue
+			}
+			for i := range candidate.models {
+				if matchModel(candidate.models[i].ID, model) {
+					found = &candidate.models[i]
+					break
+				}
+			}
+			if found != nil {
+				backend = candidate
+				settings.Provider = candidate.name
+				break
+			}
+		}
+
+		switch {
+		case found != nil && !found.Chat:
+			add("model", "model %s can't be used for chat", model)
+		case found == nil && backend != nil && backend.listed:
+			add("model", "model %s is not available on %s", model, backend.name)
+		case found == nil && backend == nil && len(c.backends) > 1:
+			add("provider", "provider is required for model %s", model)
+		case found == nil && backend == nil:
+			backend = c.backends[0]
+		}
+	} else if backend != nil {
+		model = backend.provider.DefaultModel()
+	} else if len(c.backends) > 0 {
+		model = c.backends[0].provider.DefaultModel()
+	}
+
+	maxTemperature := 2.0
+	if backend != nil {
+		if _, ok := backend.provider.(*AnthropicProvider); ok {
+			maxTemperature = 1
+		}
+	}
+	if t := settings.Temperature; t != nil && (*t < 0 || *t > maxTemperature) {
+		add("temperature", "must be between 0 and %v", maxTemperature)
+	}
+	if p := settings.TopP; p != nil && (*p <= 0 || *p > 1) {
+		add("top_p", "must be greater than 0 and at most 1")
+	}
+
+	window := tokenizer.LookupModel(model).ContextWindow
+	if settings.MaxTokens < 0 || settings.MaxTokens > window {
+		add("max_tokens", "must be between 0 (the default) and the model's context window of %d tokens", window)
+	}
+
+	return settings, errs
+}
+
+// DefaultModel returns the model a backend uses when none is chosen.
+func (c *Catalog) DefaultModel(provider string) string {
+	for _, backend := range c.backends {
+		if backend.name == provider || provider == "" {
+			return backend.provider.DefaultModel()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package llm
+
+import "testing"
+
+func TestCatalogSupportsVision(t *testing.T) {
+	router := NewRouter([]*Backend{
+		{Name: "openai", Provider: &MockLLM{}, Weight: 1},
+		{Name: "anthropic", Provider: NewAnthropicProvider("key", "claude-test", ""), Weight: 1},
+	}, false)
+	catalog := NewCatalog(router)
+	mock := (&MockLLM{}).DefaultModel()
+
+	tests := []struct {
+		name     string
+		provider string
+		model    string
+		want     bool
+	}{
+		{"vision model", "openai", mock, true},
+		{"text only model", "anthropic", "claude-test", false},
+		{"any backend", "", "claude-test", false},
+		{"unknown model", "anthropic", "claude-other", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := catalog.SupportsVision(tt.provider, tt.model); got != tt.want {
+				t.Errorf("SupportsVision(%q, %q) = %v, want %v", tt.provider, tt.model, got, tt.want)
+			}
+		})
+	}
+
+	var none *Catalog
+	if !none.SupportsVision("", "claude-test") {
+		t.Error("a nil catalog refused images")
+	}
+}
+
+func TestDescribeModelVision(t *testing.T) {
+	openai := NewOpenAIProvider("key", "gpt-4o")
+	lmStudio := NewLmStudioProvider("local-model", "http://localhost:1234/v1")
+
+	tests := []struct {
+		provider LLMProvider
+		model    string
+		want     bool
+	}{
+		{openai, "gpt-4o", true},
+		{openai, "gpt-4o-mini", true},
+		{openai, "gpt-4.1-nano", true},
+		{openai, "gpt-4-turbo", true},
+		{openai, "gpt-4-turbo-preview", false},
+		{openai, "gpt-3.5-turbo", false},
+		{openai, "o1", true},
+		{openai, "o1-mini", false},
+		{openai, "o3-mini", false},
+		{openai, "text-embedding-3-small", false},
+		{lmStudio, "llava-v1.5-7b", true},
+		{lmStudio, "qwen2.5-vl-7b-instruct", true},
+		{lmStudio, "llama-3.2-11b-vision-instruct", true},
+		{lmStudio, "openai/gpt-4o", true},
+		{lmStudio, "qwen2.5-7b-instruct", false},
+		{lmStudio, "mistral-7b-instruct-v0.1", false},
+		{&MockLLM{}, "mock", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := describeModel("backend", tt.provider, tt.model, true).Vision; got != tt.want {
+				t.Errorf("vision of %s = %v, want %v", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalogRefusesImagesForTextModels(t *testing.T) {
+	catalog := NewCatalog(NewOpenAIProvider("key", "gpt-3.5-turbo"))
+	if catalog.SupportsVision("", "gpt-3.5-turbo") {
+		t.Error("gpt-3.5-turbo is advertised with vision")
+	}
+}
@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Inputs sent in a single /v1/embeddings request; OpenAI rejects more.
+const maxEmbeddingBatch = 2048
+
+// normalize scales v to unit length in place, so similarity can be computed
+// with a plain dot product. Models trained for shortened embeddings keep
+// working when cut to dimensions first.
+func normalize(v []float32, dimensions int) []float32 {
+	if dimensions > 0 && len(v) > dimensions {
+		v = v[:dimensions]
+	}
+
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+func embeddingModelID(model string, dimensions int) string {
+	if dimensions > 0 {
+		return fmt.Sprintf("%s@%d", model, dimensions)
+	}
+	return model
+}
+
+// embedBatches splits texts into requests the provider accepts and joins the
+// vectors back in input order.
+func embedBatches(ctx context.Context, texts []string, embed func(ctx context.Context, batch []string) ([][]float32, error)) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbeddingBatch {
+		end := min(start+maxEmbeddingBatch, len(texts))
+		batch, err := embed(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// embedEach embeds texts one request at a time, for providers without batch
+// input.
+func embedEach(ctx context.Context, texts []string, embed func(ctx context.Context, text string) ([]float32, error)) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector, err := embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// firstEmbedding embeds a single text through a batch implementation.
+func firstEmbedding(vectors [][]float32, err error) ([]float32, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) == 0 {
+		return nil, errors.New("no embedding returned")
+	}
+	return vectors[0], nil
+}
+
+// hashEmbedding is a deterministic bag of words embedding: every word and
+// character trigram is hashed into a signed bucket. Texts sharing words end
+// up close together, which is enough to exercise similarity search offline.
+func hashEmbedding(text string, dimensions int) []float32 {
+	vector := make([]float32, dimensions)
+	add := func(feature string, weight float32) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+
+		sign := float32(1)
+		if sum&1 == 1 {
+			sign = -1
+		}
+		vector[(sum>>1)%uint64(dimensions)] += sign * weight
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		add("w:"+word, 1)
+
+		padded := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			add("t:"+string(padded[i:i+3]), 0.5)
+		}
+	}
+
+	return normalize(vector, 0)
+}
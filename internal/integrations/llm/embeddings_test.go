@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func norm(v []float32) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum)
+}
+
+func TestNormalize(t *testing.T) {
+	v := normalize([]float32{3, 4}, 0)
+	if v[0] != 0.6 || v[1] != 0.8 {
+		t.Errorf("normalize(3, 4) = %v, want [0.6 0.8]", v)
+	}
+
+	// Cut to the requested dimensions before scaling.
+	v = normalize([]float32{1, 1, 1, 1, 100}, 4)
+	if len(v) != 4 || math.Abs(norm(v)-1) > 1e-6 {
+		t.Errorf("normalize to 4 dimensions = %v, want 4 values of unit norm", v)
+	}
+
+	if v := normalize([]float32{0, 0}, 0); v[0] != 0 || v[1] != 0 {
+		t.Errorf("normalize(0, 0) = %v, want it unchanged", v)
+	}
+}
+
+func TestEmbedBatches(t *testing.T) {
+	texts := make([]string, 2*maxEmbeddingBatch+1)
+	for i := range texts {
+		texts[i] = strconv.Itoa(i)
+	}
+
+	var sizes []int
+	vectors, err := embedBatches(context.Background(), texts, func(ctx context.Context, batch []string) ([][]float32, error) {
+		sizes = append(sizes, len(batch))
+		out := make([][]float32, len(batch))
+		for i, text := range batch {
+			n, _ := strconv.Atoi(text)
+			out[i] = []float32{float32(n)}
+		}
+		return out, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 3 || sizes[0] != maxEmbeddingBatch || sizes[1] != maxEmbeddingBatch || sizes[2] != 1 {
+		t.Errorf("batch sizes = %v, want %d, %d and 1", sizes, maxEmbeddingBatch, maxEmbeddingBatch)
+	}
+	for i, vector := range vectors {
+		if vector[0] != float32(i) {
+			t.Fatalf("vector %d belongs to text %v, want the input order kept", i, vector[0])
+		}
+	}
+
+	failure := errors.New("rate limited")
+	calls := 0
+	_, err = embedBatches(context.Background(), texts, func(ctx context.Context, batch []string) ([][]float32, error) {
+		calls++
+		return nil, failure
+	})
+	if !errors.Is(err, failure) || calls != 1 {
+		t.Errorf("err = %v after %d calls, want the first batch's error", err, calls)
+	}
+}
+
+func TestHashEmbedding(t *testing.T) {
+	a := hashEmbedding("The quick brown fox", 64)
+	b := hashEmbedding("the QUICK brown fox!", 64)
+	if len(a) != 64 || math.Abs(norm(a)-1) > 1e-6 {
+		t.Fatalf("embedding has %d dimensions and norm %v, want 64 and 1", len(a), norm(a))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatal("case and punctuation changed the embedding")
+		}
+	}
+
+	dot := func(x, y []float32) (sum float32) {
+		for i := range x {
+			sum += x[i] * y[i]
+		}
+		return sum
+	}
+	related := hashEmbedding("a quick brown dog", 64)
+	unrelated := hashEmbedding("interest rates rose sharply", 64)
+	if dot(a, related) <= dot(a, unrelated) {
+		t.Errorf("similarity with a related text %v isn't above an unrelated one %v", dot(a, related), dot(a, unrelated))
+	}
+
+	if empty := hashEmbedding("", 8); norm(empty) != 0 {
+		t.Errorf("empty text embedding = %v, want zeros", empty)
+	}
+}
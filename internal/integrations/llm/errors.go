@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnsupported     = errors.New("not supported by this provider")
+	ErrInvalidRequest  = errors.New("invalid request")
+	ErrAuthentication  = errors.New("authentication failed")
+	ErrPermission      = errors.New("permission denied")
+	ErrNotFound        = errors.New("not found")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrOverloaded      = errors.New("provider overloaded")
+	ErrProviderFailure = errors.New("provider error")
+	// ErrModelUnavailable means the backend can't serve the model right now,
+	// although another backend may.
+	ErrModelUnavailable = errors.New("model is not available")
+)
+
+// APIError is returned when a provider answers with an error status. It
+// unwraps to one of the sentinel errors above, so callers can use errors.Is
+// without knowing which provider they talk to.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Type       string // Provider specific error type, when available
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("%s API error (%d %s): %s", e.Provider, e.StatusCode, e.Type, e.Message)
+	}
+	return fmt.Sprintf("%s API error (%d): %s", e.Provider, e.StatusCode, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusBadRequest, e.StatusCode == http.StatusRequestEntityTooLarge, e.StatusCode == http.StatusUnprocessableEntity:
+		return ErrInvalidRequest
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrAuthentication
+	case e.StatusCode == http.StatusForbidden:
+		return ErrPermission
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusServiceUnavailable, e.StatusCode == 529:
+		return ErrOverloaded
+	default:
+		return ErrProviderFailure
+	}
+}
+
+// Retryable reports whether sending the same request again may succeed.
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsRetryable reports whether a failed call may succeed if sent again, to
+// the same backend or another one. Errors caused by the request itself, or by
+// the caller giving up, are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrModelUnavailable)
+}
+
+var ErrSafetyBlocked = errors.New("blocked by safety filters")
+
+// SafetyError reports a prompt or answer that the provider refused on safety
+// grounds, with the categories that triggered it.
+type SafetyError struct {
+	Provider   string
+	Reason     string
+	Categories []string
+}
+
+func (e *SafetyError) Error() string {
+	if len(e.Categories) > 0 {
+		return fmt.Sprintf("%s blocked the content (%s): %s", e.Provider, e.Reason, strings.Join(e.Categories, ", "))
+	}
+	return fmt.Sprintf("%s blocked the content (%s)", e.Provider, e.Reason)
+}
+
+func (e *SafetyError) Unwrap() error {
+	return ErrSafetyBlocked
+}
@@ -0,0 +1,367 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/resilience"
+)
+
+const (
+	geminiBaseURL        = "https://generativelanguage.googleapis.com"
+	geminiEmbeddingModel = "text-embedding-004"
+)
+
+// GeminiProvider talks to the Google Gemini API. Like Anthropic it keeps no
+// conversation state, so the whole history is sent on every call.
+type GeminiProvider struct {
+	ApiKey         string
+	Model          string
+	EmbeddingModel string
+	BaseURL        string
+	Client         *http.Client
+}
+
+func NewGeminiProvider(apiKey string, model string, baseURL string) *GeminiProvider {
+	if baseURL == "" {
+		baseURL = geminiBaseURL
+	}
+	return &GeminiProvider{
+		ApiKey:         apiKey,
+		Model:          model,
+		EmbeddingModel: geminiEmbeddingModel,
+		BaseURL:        strings.TrimSuffix(baseURL, "/"),
+		Client:         resilience.NewClient("gemini", resilience.ConfigFromEnv("GEMINI", resilience.DefaultConfig)),
+	}
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiSafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked"`
+}
+
+type geminiResponse struct {
+	ResponseID   string `json:"responseId"`
+	ModelVersion string `json:"modelVersion"`
+	Candidates   []struct {
+		Content       geminiContent        `json:"content"`
+		FinishReason  string               `json:"finishReason"`
+		SafetyRatings []geminiSafetyRating `json:"safetyRatings"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason   string               `json:"blockReason"`
+		SafetyRatings []geminiSafetyRating `json:"safetyRatings"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// geminiRole maps our roles to Gemini's, which calls the assistant "model".
+func geminiRole(role string) string {
+	if role == "assistant" {
+		return "model"
+	}
+	return "user"
+}
+
+// geminiBlockedFinishReasons are the finish reasons that mean the answer was
+// withheld by a filter.
+var geminiBlockedFinishReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+	"IMAGE_SAFETY":       true,
+}
+
+func geminiBlockedCategories(ratings []geminiSafetyRating) []string {
+	categories := []string{}
+	for _, rating := range ratings {
+		if rating.Blocked || rating.Probability == "HIGH" {
+			categories = append(categories, rating.Category)
+		}
+	}
+	return categories
+}
+
+func (p *GeminiProvider) model(req Request) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return p.Model
+}
+
+func (p *GeminiProvider) newRequest(req Request) (geminiRequest, error) {
+	turns, err := textOnly("gemini", req.Messages)
+	if err != nil {
+		return geminiRequest{}, err
+	}
+
+	system := []geminiPart{}
+	if prompt := systemPrompt(req); prompt != "" {
+		system = append(system, geminiPart{Text: prompt})
+	}
+
+	contents := make([]geminiContent, 0, len(req.Messages))
+	for _, message := range withoutTools(turns) {
+		if message.Role == "system" {
+			system = append(system, geminiPart{Text: message.Content})
+			continue
+		}
+		role := geminiRole(message.Role)
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, geminiPart{Text: message.Content})
+			continue
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: []geminiPart{{Text: message.Content}}})
+	}
+
+	body := geminiRequest{
+		Contents: contents,
+		GenerationConfig: geminiGenerationConfig{
+			Temperature:     req.Temperature,
+			TopP:            req.TopP,
+			MaxOutputTokens: req.MaxTokens,
+			StopSequences:   req.Stop,
+		},
+	}
+	if len(system) > 0 {
+		body.SystemInstruction = &geminiContent{Parts: system}
+	}
+	return body, nil
+}
+
+// post sends a request to a model method such as "generateContent". The
+// caller is responsible for closing the response body.
+func (p *GeminiProvider) post(ctx context.Context, model string, method string, query string, body interface{}) (*http.Response, error) {
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/v1beta/models/%s:%s", p.BaseURL, model, method)
+	if query != "" {
+		url += "?" + query
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", p.ApiKey)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, geminiAPIError(resp)
+	}
+
+	return resp, nil
+}
+
+func geminiAPIError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	apiErr := &APIError{Provider: "gemini", StatusCode: resp.StatusCode, Message: string(body)}
+	if err := json.Unmarshal(body, &result); err == nil && result.Error.Message != "" {
+		apiErr.Type = result.Error.Status
+		apiErr.Message = result.Error.Message
+	}
+	return apiErr
+}
+
+// apply adds a generateContent response, or a chunk of a streamed one, to
+// resp. It returns the new text and a SafetyError when the content was
+// blocked.
+func (r *geminiResponse) apply(resp *Response) (string, error) {
+	if r.ResponseID != "" {
+		resp.ID = r.ResponseID
+	}
+	if r.ModelVersion != "" {
+		resp.Model = r.ModelVersion
+	}
+	if r.UsageMetadata.TotalTokenCount > 0 {
+		resp.Usage = Usage{
+			PromptTokens:     r.UsageMetadata.PromptTokenCount,
+			CompletionTokens: r.UsageMetadata.CandidatesTokenCount,
+			TotalTokens:      r.UsageMetadata.TotalTokenCount,
+		}
+	}
+
+	if r.PromptFeedback.BlockReason != "" {
+		resp.FinishReason = FinishContentFilter
+		return "", &SafetyError{Provider: "gemini", Reason: r.PromptFeedback.BlockReason, Categories: geminiBlockedCategories(r.PromptFeedback.SafetyRatings)}
+	}
+	if len(r.Candidates) == 0 {
+		return "", nil
+	}
+
+	candidate := r.Candidates[0]
+	var text strings.Builder
+	for _, part := range candidate.Content.Parts {
+		text.WriteString(part.Text)
+	}
+
+	switch {
+	case candidate.FinishReason == "":
+	case candidate.FinishReason == "MAX_TOKENS":
+		resp.FinishReason = FinishLength
+	case geminiBlockedFinishReasons[candidate.FinishReason]:
+		resp.FinishReason = FinishContentFilter
+		return text.String(), &SafetyError{Provider: "gemini", Reason: candidate.FinishReason, Categories: geminiBlockedCategories(candidate.SafetyRatings)}
+	default:
+		resp.FinishReason = FinishStop
+	}
+
+	return text.String(), nil
+}
+
+func (p *GeminiProvider) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	body, err := p.newRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.post(ctx, p.model(req), "generateContent", "", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	response := &Response{Model: p.model(req)}
+	text, err := result.apply(response)
+	response.Text = text
+	if err != nil {
+		return response, err
+	}
+
+	return response, nil
+}
+
+func (p *GeminiProvider) StreamResponse(ctx context.Context, req Request, onDelta func(delta string) error) (*Response, error) {
+	body, err := p.newRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.post(ctx, p.model(req), "streamGenerateContent", "alt=sse", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	partial := &Response{Model: p.model(req)}
+	var text strings.Builder
+
+	err = readEvents(resp.Body, func(event string, data string) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+
+		delta, err := chunk.apply(partial)
+		if delta != "" {
+			text.WriteString(delta)
+			if err := onDelta(delta); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if partial.FinishReason != "" {
+			return io.EOF
+		}
+		return nil
+	})
+
+	partial.Text = text.String()
+	if err != nil {
+		return partial, err
+	}
+
+	return partial, nil
+}
+
+func (p *GeminiProvider) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	body := map[string]interface{}{
+		"model":   "models/" + p.EmbeddingModel,
+		"content": geminiContent{Parts: []geminiPart{{Text: text}}},
+	}
+
+	resp, err := p.post(ctx, p.EmbeddingModel, "embedContent", "", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Embedding struct {
+			Values []float32 `json:"values"`
+		} `json:"embedding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return normalize(result.Embedding.Values, 0), nil
+}
+
+func (p *GeminiProvider) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedEach(ctx, texts, p.GenerateEmbedding)
+}
+
+func (p *GeminiProvider) DefaultModel() string {
+	return p.Model
+}
+
+func (p *GeminiProvider) EmbeddingModelID() string {
+	return p.EmbeddingModel
+}
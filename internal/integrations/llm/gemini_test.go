@@ -0,0 +1,196 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// geminiServer answers every call with status and body and records the path,
+// query and decoded request.
+func geminiServer(t *testing.T, status int, body string) (*GeminiProvider, *geminiRequest, *string) {
+	t.Helper()
+	var got geminiRequest
+	var target string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.URL.RequestURI()
+		if r.Header.Get("x-goog-api-key") != "key" {
+			t.Errorf("missing API key header: %v", r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	provider := NewGeminiProvider("key", "gemini-test", server.URL)
+	provider.Client = server.Client()
+	return provider, &got, &target
+}
+
+func TestGeminiRequestMapping(t *testing.T) {
+	temperature := 0.2
+	tests := []struct {
+		name   string
+		req    Request
+		target string
+		want   geminiRequest
+	}{
+		{
+			name:   "defaults",
+			req:    Request{Messages: []Message{{Role: "user", Content: "hi"}}},
+			target: "/v1beta/models/gemini-test:generateContent",
+			want: geminiRequest{
+				Contents: []geminiContent{{Role: "user", Parts: []geminiPart{{Text: "hi"}}}},
+			},
+		},
+		{
+			name: "overrides",
+			req: Request{
+				Model:       "gemini-other",
+				Messages:    []Message{{Role: "user", Content: "hi"}},
+				Temperature: &temperature,
+				MaxTokens:   10,
+				Stop:        []string{"END"},
+			},
+			target: "/v1beta/models/gemini-other:generateContent",
+			want: geminiRequest{
+				Contents: []geminiContent{{Role: "user", Parts: []geminiPart{{Text: "hi"}}}},
+				GenerationConfig: geminiGenerationConfig{
+					Temperature:     &temperature,
+					MaxOutputTokens: 10,
+					StopSequences:   []string{"END"},
+				},
+			},
+		},
+		{
+			name: "system turns and model role",
+			req: Request{
+				System: "be brief",
+				Messages: []Message{
+					{Role: "system", Content: "summary"},
+					{Role: "user", Content: "a"},
+					{Role: "user", Content: "b"},
+					{Role: "assistant", Content: "c"},
+				},
+			},
+			target: "/v1beta/models/gemini-test:generateContent",
+			want: geminiRequest{
+				Contents: []geminiContent{
+					{Role: "user", Parts: []geminiPart{{Text: "a"}, {Text: "b"}}},
+					{Role: "model", Parts: []geminiPart{{Text: "c"}}},
+				},
+				SystemInstruction: &geminiContent{Parts: []geminiPart{{Text: "be brief"}, {Text: "summary"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, got, target := geminiServer(t, http.StatusOK, `{"candidates":[{"content":{"parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`)
+			if _, err := provider.GenerateResponse(context.Background(), tt.req); err != nil {
+				t.Fatal(err)
+			}
+			if *target != tt.target {
+				t.Errorf("target = %s, want %s", *target, tt.target)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("request = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeminiGenerateResponse(t *testing.T) {
+	provider, _, _ := geminiServer(t, http.StatusOK, `{
+		"responseId": "resp_1",
+		"modelVersion": "gemini-test-001",
+		"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}, {"text": " there"}]}, "finishReason": "MAX_TOKENS"}],
+		"usageMetadata": {"promptTokenCount": 3, "candidatesTokenCount": 2, "totalTokenCount": 5}
+	}`)
+
+	resp, err := provider.GenerateResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Response{
+		Text:         "Hello there",
+		ID:           "resp_1",
+		FinishReason: FinishLength,
+		Model:        "gemini-test-001",
+		Usage:        Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestGeminiStreamResponse(t *testing.T) {
+	chunks := []string{
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Hel"}]}}],"modelVersion":"gemini-test-001"}`,
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"lo"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":2,"totalTokenCount":5}}`,
+	}
+	provider, _, target := geminiServer(t, http.StatusOK, strings.Join(chunks, "\n\n")+"\n\n")
+
+	var deltas []string
+	resp, err := provider.StreamResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *target != "/v1beta/models/gemini-test:streamGenerateContent?alt=sse" {
+		t.Errorf("target = %s", *target)
+	}
+	if !reflect.DeepEqual(deltas, []string{"Hel", "lo"}) {
+		t.Errorf("deltas = %q", deltas)
+	}
+	want := &Response{
+		Text:         "Hello",
+		FinishReason: FinishStop,
+		Model:        "gemini-test-001",
+		Usage:        Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestGeminiErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"invalid request", http.StatusBadRequest, `{"error":{"code":400,"message":"bad","status":"INVALID_ARGUMENT"}}`, ErrInvalidRequest},
+		{"permission", http.StatusForbidden, `{"error":{"code":403,"message":"no key","status":"PERMISSION_DENIED"}}`, ErrPermission},
+		{"rate limited", http.StatusTooManyRequests, `{"error":{"code":429,"message":"quota","status":"RESOURCE_EXHAUSTED"}}`, ErrRateLimited},
+		{"unavailable", http.StatusServiceUnavailable, `{"error":{"code":503,"message":"busy","status":"UNAVAILABLE"}}`, ErrOverloaded},
+		{"blocked prompt", http.StatusOK, `{"promptFeedback":{"blockReason":"SAFETY","safetyRatings":[{"category":"HARM_CATEGORY_HARASSMENT","probability":"HIGH"}]}}`, ErrSafetyBlocked},
+		{"blocked answer", http.StatusOK, `{"candidates":[{"content":{"parts":[{"text":"partial"}]},"finishReason":"RECITATION"}]}`, ErrSafetyBlocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, _, _ := geminiServer(t, tt.status, tt.body)
+			resp, err := provider.GenerateResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			if tt.want == ErrSafetyBlocked && resp.FinishReason != FinishContentFilter {
+				t.Errorf("finish reason = %q, want %q", resp.FinishReason, FinishContentFilter)
+			}
+		})
+	}
+}
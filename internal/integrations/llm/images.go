@@ -0,0 +1,42 @@
+package llm
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// Image is a picture attached to a user turn, sent to providers with vision
+// support.
+type Image struct {
+	MIMEType string `json:"mime_type"` // "image/png", "image/jpeg", ...
+	Data     []byte `json:"data"`
+}
+
+// DataURL encodes the image as a data: URL, which is how OpenAI compatible
+// APIs take inline images.
+func (i Image) DataURL() string {
+	return "data:" + i.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(i.Data)
+}
+
+// textOnly prepares the turns for a provider without vision support. Images
+// in the latest user turn are the question itself, so they fail the request
+// with ErrUnsupported; older ones are dropped, which keeps a chat usable after
+// it moved to such a provider.
+func textOnly(provider string, messages []Message) ([]Message, error) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "user" {
+			continue
+		}
+		if len(messages[i].Images) > 0 {
+			return nil, fmt.Errorf("%s can't read images: %w", provider, ErrUnsupported)
+		}
+		break
+	}
+
+	plain := make([]Message, len(messages))
+	for i, message := range messages {
+		message.Images = nil
+		plain[i] = message
+	}
+	return plain, nil
+}
@@ -1,34 +1,450 @@
 package llm
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/jsonschema"
+	"github.com/gofiber/fiber/v2/log"
 )
 
+// Message is a single conversation turn sent to the provider.
+type Message struct {
+	Role    string `json:"role"` // "system", "user", "assistant" or "tool"
+	Content string `json:"content"`
+	// ToolCalls are the calls an assistant turn made, ToolCallID the call a
+	// tool turn answers.
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// Images attached to a user turn, only read by providers with vision
+	// support.
+	Images []Image `json:"images,omitempty"`
+}
+
+// Request describes a generation. Zero values leave the provider defaults in
+// place.
+type Request struct {
+	Model      string    // Overrides the provider model
+	Provider   string    // Pins the Router to a single backend
+	System     string    // System instructions
+	Messages   []Message // Conversation turns, oldest first
+	PreviousID string    // Provider ID of the previous response, for providers that keep state
+	// Backend that produced PreviousID, Router only passes it on to that one
+	PreviousProvider string
+	Temperature      *float64
+	TopP             *float64
+	MaxTokens        int
+	Stop             []string
+	Tools            []Tool // Functions the model may call instead of answering
+	// ResponseFormat asks for a JSON reply; see GenerateStructured
+	ResponseFormat *ResponseFormat
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Finish reasons reported in Response.FinishReason.
+const (
+	FinishStop          = "stop"
+	FinishLength        = "length"
+	FinishContentFilter = "content_filter"
+	FinishToolCalls     = "tool_calls"
+)
+
+type Response struct {
+	Text         string
+	ID           string     // Provider message ID, used as the next Request.PreviousID
+	ToolCalls    []ToolCall // Calls to run before the model can answer
+	FinishReason string
+	Usage        Usage
+	Model        string
+	Provider     string   // Backend that answered, set by Router
+	Failovers    []string // Backends that failed before, with their errors
+}
+
 type LLMProvider interface {
-	GenerateResponse(prompt string, previousId string) (string, string, error)
-	GenerateEmbedding(text string) ([]float32, error)
+	GenerateResponse(ctx context.Context, req Request) (*Response, error)
+	// StreamResponse works like GenerateResponse but calls onDelta with every
+	// chunk of text as it arrives. If the stream is interrupted, either by the
+	// provider, the context or onDelta returning an error, the response
+	// received so far is returned together with the error.
+	StreamResponse(ctx context.Context, req Request, onDelta func(delta string) error) (*Response, error)
+	GenerateEmbedding(ctx context.Context, text string) ([]float32, error)
+	// GenerateEmbeddings embeds every text, returning unit length vectors in
+	// input order.
+	GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error)
+	// EmbeddingModelID identifies the vectors GenerateEmbedding returns: the
+	// embedding model, with the dimensions when they are shortened. Vectors
+	// are only comparable under the same ID.
+	EmbeddingModelID() string
+	// DefaultModel is the model used when the request doesn't name one. It
+	// sizes the prompt to the model's context window.
+	DefaultModel() string
 }
 
+// NewLLMProvider builds the provider from the environment. LLM_PROVIDERS takes
+// a comma separated list of backends, tried in order (LLM_ROUTING=failover,
+// the default) or picked by weight (LLM_ROUTING=weighted, with LLM_WEIGHTS
+// such as "openai:3,lmstudio:1"). LLM_PROVIDER configures a single backend.
+//
+// LLM_PROVIDER=replay answers from the cassette at LLM_CASSETTE instead of a
+// backend, and LLM_RECORD=true records every call to the configured backends
+// into it, written when the returned provider is closed.
 func NewLLMProvider() (LLMProvider, error) {
-	provider := os.Getenv("LLM_PROVIDER")
+	if os.Getenv("LLM_PROVIDER") == "replay" && os.Getenv("LLM_PROVIDERS") == "" {
+		return NewReplayer(cassettePath())
+	}
+
+	names := strings.Split(os.Getenv("LLM_PROVIDERS"), ",")
+	if os.Getenv("LLM_PROVIDERS") == "" {
+		names = []string{os.Getenv("LLM_PROVIDER")}
+	}
+
+	weights := map[string]int{}
+	for _, pair := range strings.Split(os.Getenv("LLM_WEIGHTS"), ",") {
+		name, weight, _ := strings.Cut(pair, ":")
+		if w, err := strconv.Atoi(strings.TrimSpace(weight)); err == nil {
+			weights[strings.TrimSpace(name)] = w
+		}
+	}
 
+	backends := []*Backend{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		provider, err := newProvider(name)
+		if err != nil {
+			log.Error("Skipping LLM backend ", name, ": ", err)
+			continue
+		}
+
+		weight, ok := weights[name]
+		if !ok {
+			weight = 1
+		}
+		backends = append(backends, &Backend{Name: name, Provider: provider, Weight: weight})
+	}
+
+	if len(backends) == 0 {
+		return nil, errors.New("invalid LLM provider")
+	}
+
+	router := NewRouter(backends, os.Getenv("LLM_ROUTING") == "weighted")
+	if threshold, err := strconv.Atoi(os.Getenv("LLM_FAILURE_THRESHOLD")); err == nil && threshold > 0 {
+		router.FailureThreshold = threshold
+	}
+	if cooldown, err := time.ParseDuration(os.Getenv("LLM_COOLDOWN")); err == nil {
+		router.Cooldown = cooldown
+	}
+	if record, _ := strconv.ParseBool(os.Getenv("LLM_RECORD")); record {
+		return NewRecorder(router, cassettePath()), nil
+	}
+	return router, nil
+}
+
+// newProvider builds a single backend. Only OpenAI can keep the conversation
+// on its side (OPENAI_CONVERSATION_STATE=server, the default, or client); the
+// other providers always get the history replayed.
+func newProvider(provider string) (LLMProvider, error) {
 	switch provider {
 	case "openai":
-		return NewOpenAIProvider(os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_API_MODEL")), nil
+		openai := NewOpenAIProvider(os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_API_MODEL"))
+		openai.ServerState = os.Getenv("OPENAI_CONVERSATION_STATE") != "client"
+		if model := os.Getenv("OPENAI_EMBEDDING_MODEL"); model != "" {
+			openai.EmbeddingModel = model
+		}
+		openai.EmbeddingDimensions, _ = strconv.Atoi(os.Getenv("OPENAI_EMBEDDING_DIMENSIONS"))
+		return openai, nil
 	case "lmstudio":
-		return NewLmStudioProvider(os.Getenv("LM_STUDIO_MODEL"), os.Getenv("LM_STUDIO_URL")), nil
+		lmStudio := NewLmStudioProvider(os.Getenv("LM_STUDIO_MODEL"), os.Getenv("LM_STUDIO_URL"))
+		if model := os.Getenv("LM_STUDIO_EMBEDDING_MODEL"); model != "" {
+			lmStudio.EmbeddingModel = model
+		}
+		lmStudio.EmbeddingDimensions, _ = strconv.Atoi(os.Getenv("LM_STUDIO_EMBEDDING_DIMENSIONS"))
+		return lmStudio, nil
+	case "anthropic":
+		anthropic := NewAnthropicProvider(os.Getenv("ANTHROPIC_API_KEY"), os.Getenv("ANTHROPIC_MODEL"), os.Getenv("ANTHROPIC_BASE_URL"))
+		if maxTokens, err := strconv.Atoi(os.Getenv("ANTHROPIC_MAX_TOKENS")); err == nil && maxTokens > 0 {
+			anthropic.MaxTokens = maxTokens
+		}
+		return anthropic, nil
+	case "gemini":
+		gemini := NewGeminiProvider(os.Getenv("GEMINI_API_KEY"), os.Getenv("GEMINI_MODEL"), os.Getenv("GEMINI_BASE_URL"))
+		if model := os.Getenv("GEMINI_EMBEDDING_MODEL"); model != "" {
+			gemini.EmbeddingModel = model
+		}
+		return gemini, nil
+	case "ollama":
+		ollama := NewOllamaProvider(os.Getenv("OLLAMA_MODEL"), os.Getenv("OLLAMA_URL"))
+		if model := os.Getenv("OLLAMA_EMBEDDING_MODEL"); model != "" {
+			ollama.EmbeddingModel = model
+		}
+		pull, _ := strconv.ParseBool(os.Getenv("OLLAMA_PULL"))
+		if err := ollama.EnsureModel(context.Background(), pull); err != nil {
+			if errors.Is(err, ErrModelNotPulled) {
+				return nil, err
+			}
+			// Ollama may simply not be up yet; calls will fail until it is.
+			log.Warn("Could not check Ollama models: ", err)
+		}
+		return ollama, nil
+	case "mock":
+		mock := &MockLLM{}
+		// LLM_MOCK_RULES scripts the mock from a YAML or JSON rules file.
+		if path := os.Getenv("LLM_MOCK_RULES"); path != "" {
+			rules, err := LoadMockRules(path)
+			if err != nil {
+				return nil, err
+			}
+			mock.Rules = rules
+		}
+		return mock, nil
 	default:
-		return nil, errors.New("invalid LLM provider")
+		return nil, fmt.Errorf("unknown LLM provider %q", provider)
+	}
+}
+
+// truncateAtStop cuts text at the first stop sequence, for providers that
+// don't support them natively.
+func truncateAtStop(text string, stop []string) (string, bool) {
+	cut := -1
+	for _, s := range stop {
+		if i := strings.Index(text, s); s != "" && i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+	if cut < 0 {
+		return text, false
+	}
+	return text[:cut], true
+}
+
+// stopFilter applies stop sequences to a stream. It holds back text that could
+// be the beginning of a stop sequence until it knows whether it completes.
+type stopFilter struct {
+	stop    []string
+	pending string
+	stopped bool
+}
+
+// write returns the text that is safe to emit and whether a stop sequence was
+// reached.
+func (f *stopFilter) write(delta string) (string, bool) {
+	if f.stopped {
+		return "", true
+	}
+
+	text, stopped := truncateAtStop(f.pending+delta, f.stop)
+	f.pending = ""
+	if stopped {
+		f.stopped = true
+		return text, true
+	}
+
+	hold := 0
+	for _, s := range f.stop {
+		for n := min(len(s)-1, len(text)); n > hold; n-- {
+			if strings.HasSuffix(text, s[:n]) {
+				hold = n
+				break
+			}
+		}
 	}
+	f.pending = text[len(text)-hold:]
+	return text[:len(text)-hold], false
 }
 
-type MockLLM struct{}
+// flush returns the text held back once the stream is over.
+func (f *stopFilter) flush() string {
+	pending := f.pending
+	f.pending = ""
+	return pending
+}
+
+// lastUserMessage returns the content of the latest user turn in the request.
+func lastUserMessage(req Request) string {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			return req.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// messagesAfterLastReply returns the turns that follow the latest assistant
+// message.
+func messagesAfterLastReply(messages []Message) []Message {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "assistant" {
+			return messages[i+1:]
+		}
+	}
+	return messages
+}
+
+const mockResponse = "This is a mock response from the LLM."
+
+// mockEmbeddingDimensions matches OpenAI's text-embedding-3-small, so the
+// mock can stand in for it against the same collection.
+const mockEmbeddingDimensions = 1536
+
+// MockLLM is a fake backend for development. Without Rules it answers with
+// a fixed sentence, calls the tools named in the prompt and returns schema
+// examples for structured requests; Rules script it further.
+type MockLLM struct {
+	EmbeddingDimensions int // Defaults to mockEmbeddingDimensions
+	Rules               *MockRules
+}
 
-func (m *MockLLM) GenerateResponse(prompt string, previousId string) (string, string, error) {
-	return "This is a mock response from the LLM.", "", nil
+func (m *MockLLM) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	return m.StreamResponse(ctx, req, func(string) error { return nil })
 }
 
-func (m *MockLLM) GenerateEmbedding(text string) ([]float32, error) {
-	return make([]float32, 1536), nil // Return empty vector of size 1536
+func (m *MockLLM) StreamResponse(ctx context.Context, req Request, onDelta func(delta string) error) (*Response, error) {
+	resp := &Response{
+		FinishReason: FinishStop,
+		Model:        "mock",
+		Usage: Usage{
+			PromptTokens: len(strings.Fields(req.System + " " + lastUserMessage(req))),
+		},
+	}
+
+	// Rules answer user messages; tool results still go to the tool flow
+	// below.
+	if m.Rules != nil && (len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Role != "tool") {
+		prompt := lastUserMessage(req)
+		if rule, groups := m.Rules.match(prompt); rule != nil {
+			return m.answer(ctx, rule, req, prompt, groups, resp, onDelta)
+		}
+	}
+
+	// With tools, the mock calls every tool named in the user message, then
+	// reports what they returned. Structured requests get an example of the
+	// schema.
+	text := mockResponse
+	if req.ResponseFormat != nil {
+		example, _ := json.Marshal(jsonschema.Example(req.ResponseFormat.Schema))
+		text = string(example)
+	} else if len(req.Tools) > 0 && len(req.Messages) > 0 {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == "tool" {
+			text = "The tool returned: " + last.Content
+		} else {
+			for _, tool := range req.Tools {
+				if strings.Contains(last.Content, tool.Name) {
+					resp.ToolCalls = append(resp.ToolCalls, ToolCall{
+						ID:        fmt.Sprintf("call_mock_%d", len(resp.ToolCalls)+1),
+						Name:      tool.Name,
+						Arguments: "{}",
+					})
+				}
+			}
+		}
+	}
+	if len(resp.ToolCalls) > 0 {
+		resp.FinishReason = FinishToolCalls
+		resp.Usage.TotalTokens = resp.Usage.PromptTokens
+		return resp, nil
+	}
+
+	return m.stream(ctx, req, resp, text, mockPacing{}, onDelta)
+}
+
+// mockWords splits text in words, each with the whitespace before it and
+// the last one with the trailing whitespace, so streamed markdown keeps its
+// line breaks.
+var mockWords = regexp.MustCompile(`\s*\S+(?:\s+$)?`)
+
+// stream sends text through onDelta paced as asked, counting one completion
+// token per word.
+func (m *MockLLM) stream(ctx context.Context, req Request, resp *Response, text string, pacing mockPacing, onDelta func(delta string) error) (*Response, error) {
+	words := mockWords.FindAllString(text, -1)
+	chunkWords := max(pacing.chunkWords, 1)
+
+	filter := stopFilter{stop: req.Stop}
+	for i, chunks := 0, 0; i < len(words); i, chunks = i+chunkWords, chunks+1 {
+		if pacing.fail != nil && chunks == pacing.failAfter {
+			return resp, pacing.fail
+		}
+		if chunks > 0 {
+			if err := sleep(ctx, pacing.chunkDelay); err != nil {
+				return resp, err
+			}
+		} else if err := ctx.Err(); err != nil {
+			return resp, err
+		}
+		if req.MaxTokens > 0 && i >= req.MaxTokens {
+			resp.FinishReason = FinishLength
+			break
+		}
+
+		end := min(i+chunkWords, len(words))
+		if req.MaxTokens > 0 {
+			end = min(end, req.MaxTokens)
+		}
+		delta, stopped := filter.write(strings.Join(words[i:end], ""))
+		resp.Text += delta
+		resp.Usage.CompletionTokens += end - i
+		if delta != "" {
+			if err := onDelta(delta); err != nil {
+				return resp, err
+			}
+		}
+		if stopped {
+			break
+		}
+	}
+	if rest := filter.flush(); rest != "" {
+		resp.Text += rest
+		if err := onDelta(rest); err != nil {
+			return resp, err
+		}
+	}
+
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens + resp.Usage.CompletionTokens
+	// A reply shorter than failAfter chunks still fails, once it's all sent.
+	if pacing.fail != nil {
+		return resp, pacing.fail
+	}
+	return resp, nil
+}
+
+func (m *MockLLM) DefaultModel() string {
+	return "mock"
+}
+
+func (m *MockLLM) GetModels(ctx context.Context) ([]string, error) {
+	return []string{m.DefaultModel(), "mock-hash"}, nil
+}
+
+func (m *MockLLM) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	return firstEmbedding(m.GenerateEmbeddings(ctx, []string{text}))
+}
+
+func (m *MockLLM) embeddingDimensions() int {
+	if m.EmbeddingDimensions <= 0 {
+		return mockEmbeddingDimensions
+	}
+	return m.EmbeddingDimensions
+}
+
+func (m *MockLLM) EmbeddingModelID() string {
+	return embeddingModelID("mock-hash", m.embeddingDimensions())
+}
+
+func (m *MockLLM) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = hashEmbedding(text, m.embeddingDimensions())
+	}
+	return vectors, nil
 }
@@ -1,40 +1,51 @@
 package llm
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"slices"
+	"strings"
 
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/resilience"
 	"github.com/gofiber/fiber/v2/log"
 )
 
+const lmStudioEmbeddingModel = "text-embedding-nomic-embed-text-v1.5"
+
 type LmStudioProvider struct {
-	Model   string
-	BaseURL string
+	Model string
+	// EmbeddingModel and EmbeddingDimensions configure embeddings separately
+	// from chat. Zero dimensions keeps the model's native size.
+	EmbeddingModel      string
+	EmbeddingDimensions int
+	BaseURL             string
+	Client              *http.Client
 }
 
 func NewLmStudioProvider(model string, baseURL string) *LmStudioProvider {
 	return &LmStudioProvider{
-		Model:   model,
-		BaseURL: baseURL,
+		Model:          model,
+		EmbeddingModel: lmStudioEmbeddingModel,
+		BaseURL:        baseURL,
+		Client:         resilience.NewClient("lmstudio", resilience.ConfigFromEnv("LM_STUDIO", resilience.DefaultConfig)),
 	}
 }
 
-func (p *LmStudioProvider) GetModels() ([]string, error) {
+func (p *LmStudioProvider) GetModels(ctx context.Context) ([]string, error) {
 	url := p.BaseURL + "/models"
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := p.Client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +53,7 @@ func (p *LmStudioProvider) GetModels() ([]string, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error: %s", string(body))
+		return nil, &APIError{Provider: "lmstudio", StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	var result struct {
@@ -64,78 +75,370 @@ func (p *LmStudioProvider) GetModels() ([]string, error) {
 	return models, nil
 }
 
-func (p *LmStudioProvider) GenerateResponse(prompt string, previousId string) (string, string, error) {
-	models, err := p.GetModels()
-	if err != nil {
-		return "", "", err
+// chatCompletion sends a chat completion request. Models are checked against
+// the Catalog, not here. The caller is responsible for closing the response
+// body.
+func (p *LmStudioProvider) chatCompletion(ctx context.Context, request Request, stream bool) (*http.Response, error) {
+	model := p.Model
+	if request.Model != "" {
+		model = request.Model
 	}
 
-	if !slices.Contains(models, p.Model) {
-		return "", "", fmt.Errorf("model %s not found", p.Model)
+	messages := make([]lmStudioMessage, 0, len(request.Messages)+1)
+	if prompt := systemPrompt(request); prompt != "" {
+		messages = append(messages, lmStudioMessage{Role: "system", Content: prompt})
+	}
+	for _, message := range request.Messages {
+		messages = append(messages, toLmStudioMessage(message))
 	}
 
-	log.Info("Models: ", models)
+	temperature := 0.7
+	if request.Temperature != nil {
+		temperature = *request.Temperature
+	}
 
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"model": p.Model, // Default, LM Studio might ignore or require specific
-		"messages": []map[string]string{
-			{"role": "user", "content": prompt},
-		},
-		"temperature": 0.7,
-		"max_tokens":  -1,
-		"stream":      false,
-	})
+	maxTokens := -1
+	if request.MaxTokens > 0 {
+		maxTokens = request.MaxTokens
+	}
+
+	body := map[string]interface{}{
+		"model":       model,
+		"messages":    messages,
+		"temperature": temperature,
+		"max_tokens":  maxTokens,
+		"stream":      stream,
+	}
+	if request.TopP != nil {
+		body["top_p"] = *request.TopP
+	}
+	if len(request.Stop) > 0 {
+		body["stop"] = request.Stop
+	}
+	if len(request.Tools) > 0 {
+		tools := make([]map[string]interface{}, len(request.Tools))
+		for i, tool := range request.Tools {
+			tools[i] = map[string]interface{}{
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":        tool.Name,
+					"description": tool.Description,
+					"parameters":  toolParameters(tool),
+				},
+			}
+		}
+		body["tools"] = tools
+	}
+	if stream {
+		body["stream_options"] = map[string]bool{"include_usage": true}
+	}
+
+	requestBody, err := json.Marshal(body)
 	if err != nil {
 		log.Error(requestBody, err)
-		return "", "", err
+		return nil, err
 	}
 
 	url := p.BaseURL + "/chat/completions"
 
 	log.Info("Requesting LLM: ", url)
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := p.Client.Do(req)
 	if err != nil {
 		log.Error(err)
-		return "", "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
 		log.Error("API error: ", string(body))
-		return "", "", fmt.Errorf("API error: %s", string(body))
+		return nil, &APIError{Provider: "lmstudio", StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	return resp, nil
+}
+
+// lmStudioMessage and lmStudioToolCall are the OpenAI chat completions wire
+// format of Message and ToolCall.
+type lmStudioMessage struct {
+	Role       string             `json:"role"`
+	Content    interface{}        `json:"content"` // A string, or []lmStudioPart with images
+	ToolCalls  []lmStudioToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string             `json:"tool_call_id,omitempty"`
+}
+
+// lmStudioPart is a text or image_url content part.
+type lmStudioPart struct {
+	Type     string            `json:"type"`
+	Text     string            `json:"text,omitempty"`
+	ImageURL *lmStudioImageURL `json:"image_url,omitempty"`
+}
+
+type lmStudioImageURL struct {
+	URL string `json:"url"`
+}
+
+type lmStudioToolCall struct {
+	Index    int    `json:"index,omitempty"` // Only set in stream deltas
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+func toLmStudioMessage(message Message) lmStudioMessage {
+	wire := lmStudioMessage{Role: message.Role, Content: message.Content, ToolCallID: message.ToolCallID}
+	if len(message.Images) > 0 {
+		parts := []lmStudioPart{}
+		if message.Content != "" {
+			parts = append(parts, lmStudioPart{Type: "text", Text: message.Content})
+		}
+		for _, image := range message.Images {
+			parts = append(parts, lmStudioPart{Type: "image_url", ImageURL: &lmStudioImageURL{URL: image.DataURL()}})
+		}
+		wire.Content = parts
+	}
+	for _, call := range message.ToolCalls {
+		toolCall := lmStudioToolCall{ID: call.ID, Type: "function"}
+		toolCall.Function.Name = call.Name
+		toolCall.Function.Arguments = call.Arguments
+		wire.ToolCalls = append(wire.ToolCalls, toolCall)
 	}
+	return wire
+}
+
+func fromLmStudioToolCalls(calls []lmStudioToolCall) []ToolCall {
+	var toolCalls []ToolCall
+	for _, call := range calls {
+		toolCalls = append(toolCalls, ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+	return toolCalls
+}
+
+type lmStudioUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *lmStudioUsage) toUsage() Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+}
+
+func (p *LmStudioProvider) GenerateResponse(ctx context.Context, request Request) (*Response, error) {
+	resp, err := p.chatCompletion(ctx, request, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
 	var result struct {
 		Id      string `json:"id"`
+		Model   string `json:"model"`
 		Choices []struct {
 			Message struct {
-				Content string `json:"content"`
+				Content   string             `json:"content"`
+				ToolCalls []lmStudioToolCall `json:"tool_calls"`
 			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
 		} `json:"choices"`
+		Usage *lmStudioUsage `json:"usage"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	if len(result.Choices) > 0 {
-		return result.Choices[0].Message.Content, result.Id, nil
+		return &Response{
+			Text:         result.Choices[0].Message.Content,
+			ID:           result.Id,
+			ToolCalls:    fromLmStudioToolCalls(result.Choices[0].Message.ToolCalls),
+			FinishReason: result.Choices[0].FinishReason,
+			Usage:        result.Usage.toUsage(),
+			Model:        result.Model,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no response from LLM")
+}
+
+func (p *LmStudioProvider) StreamResponse(ctx context.Context, request Request, onDelta func(delta string) error) (*Response, error) {
+	resp, err := p.chatCompletion(ctx, request, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	partial := &Response{}
+	var text strings.Builder
+	// Tool calls arrive in pieces, keyed by their index.
+	var toolCalls []lmStudioToolCall
+	done := func() {
+		partial.Text = text.String()
+		partial.ToolCalls = fromLmStudioToolCalls(toolCalls)
+	}
+
+	// LM Studio streams OpenAI compatible chunks as server-sent events:
+	// "data: {...}" lines terminated by "data: [DONE]".
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done()
+			return partial, nil
+		}
+
+		var chunk struct {
+			Id      string `json:"id"`
+			Model   string `json:"model"`
+			Choices []struct {
+				Delta struct {
+					Content   string             `json:"content"`
+					ToolCalls []lmStudioToolCall `json:"tool_calls"`
+				} `json:"delta"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *lmStudioUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			done()
+			return partial, err
+		}
+
+		partial.ID = chunk.Id
+		partial.Model = chunk.Model
+		if chunk.Usage != nil {
+			partial.Usage = chunk.Usage.toUsage()
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			partial.FinishReason = chunk.Choices[0].FinishReason
+		}
+		for _, call := range chunk.Choices[0].Delta.ToolCalls {
+			if call.Index < 0 {
+				continue
+			}
+			for len(toolCalls) <= call.Index {
+				toolCalls = append(toolCalls, lmStudioToolCall{})
+			}
+			merged := &toolCalls[call.Index]
+			if call.ID != "" {
+				merged.ID = call.ID
+			}
+			merged.Function.Name += call.Function.Name
+			merged.Function.Arguments += call.Function.Arguments
+		}
+
+		delta := chunk.Choices[0].Delta.Content
+		if delta == "" {
+			continue
+		}
+		text.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			done()
+			return partial, err
+		}
+	}
+
+	done()
+	if err := scanner.Err(); err != nil {
+		return partial, err
+	}
+
+	return partial, fmt.Errorf("stream ended unexpectedly")
+}
+
+func (p *LmStudioProvider) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	return firstEmbedding(p.GenerateEmbeddings(ctx, []string{text}))
+}
+
+func (p *LmStudioProvider) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedBatches(ctx, texts, p.embed)
+}
+
+// embed calls the OpenAI compatible /v1/embeddings endpoint.
+func (p *LmStudioProvider) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body := map[string]interface{}{
+		"model": p.EmbeddingModel,
+		"input": texts,
+	}
+	if p.EmbeddingDimensions > 0 {
+		body["dimensions"] = p.EmbeddingDimensions
+	}
+
+	requestBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.BaseURL+"/embeddings", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{Provider: "lmstudio", StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
-	return "", "", fmt.Errorf("no response from LLM")
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("lmstudio returned %d embeddings for %d inputs", len(result.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, data := range result.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return nil, fmt.Errorf("lmstudio returned embedding index %d for %d inputs", data.Index, len(texts))
+		}
+		vectors[data.Index] = normalize(data.Embedding, p.EmbeddingDimensions)
+	}
+	return vectors, nil
+}
+
+func (p *LmStudioProvider) DefaultModel() string {
+	return p.Model
 }
 
-func (p *LmStudioProvider) GenerateEmbedding(text string) ([]float32, error) {
-	// TODO: Implement OpenAI embedding API
-	return nil, fmt.Errorf("openai embedding not implemented")
+func (p *LmStudioProvider) EmbeddingModelID() string {
+	return embeddingModelID(p.EmbeddingModel, p.EmbeddingDimensions)
 }
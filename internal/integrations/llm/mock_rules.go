@@ -0,0 +1,282 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MockRules script MockLLM. The first rule matching the last user message
+// answers it; Default answers the rest, and without it the mock behaves as
+// if it had no rules.
+type MockRules struct {
+	Default *MockRule  `json:"default"`
+	Rules   []MockRule `json:"rules"`
+}
+
+// MockRule describes one scripted answer. A rule without Match or Regex
+// matches every prompt.
+type MockRule struct {
+	Name  string `json:"name"`
+	Match string `json:"match"` // Exact prompt, surrounding space ignored
+	Regex string `json:"regex"` // Go regexp, e.g. "(?i)^weather in (?P<city>.+)"
+
+	// Reply is a text/template rendered with MockReplyData. Echo sends the
+	// prompt back instead.
+	Reply string `json:"reply"`
+	Echo  bool   `json:"echo"`
+
+	// Latency is waited before anything is sent, then the reply is streamed
+	// ChunkWords words at a time (one by default) every ChunkDelay.
+	Latency    MockDuration `json:"latency"`
+	ChunkDelay MockDuration `json:"chunk_delay"`
+	ChunkWords int          `json:"chunk_words"`
+
+	// Error fails the call: an HTTP status such as "429" or "500",
+	// "timeout" or "safety". With ErrorAfter the stream is cut after that
+	// many chunks instead, or after the last one for shorter replies.
+	Error      string `json:"error"`
+	ErrorAfter int    `json:"error_after"`
+
+	regex    *regexp.Regexp
+	template *template.Template
+}
+
+// MockReplyData is what reply templates render with.
+type MockReplyData struct {
+	Prompt string            // Last user message
+	Groups []string          // Regex submatches, Groups[0] being the whole match
+	Named  map[string]string // Named regex groups
+	Model  string
+	System string
+	Turn   int // User messages so far, counting this one
+	Images int // Images attached to the last user message
+}
+
+// MockDuration is a duration written as a Go duration string, like "250ms".
+type MockDuration time.Duration
+
+func (d *MockDuration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"250ms\": %w", err)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = MockDuration(duration)
+	return nil
+}
+
+func (d MockDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+var mockTemplateFuncs = template.FuncMap{
+	"repeat": func(count int, s string) string { return strings.Repeat(s, max(count, 0)) },
+	"upper":  strings.ToUpper,
+	"lower":  strings.ToLower,
+	"trim":   strings.TrimSpace,
+}
+
+// LoadMockRules reads a rules file, YAML when its extension is .yaml or
+// .yml and JSON otherwise.
+func LoadMockRules(path string) (*MockRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML goes through JSON so both formats share the same field names.
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		var document interface{}
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("mock rules %s: %w", path, err)
+		}
+		if data, err = json.Marshal(document); err != nil {
+			return nil, fmt.Errorf("mock rules %s: %w", path, err)
+		}
+	}
+
+	var rules MockRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("mock rules %s: %w", path, err)
+	}
+	if err := rules.compile(); err != nil {
+		return nil, fmt.Errorf("mock rules %s: %w", path, err)
+	}
+	return &rules, nil
+}
+
+func (r *MockRules) compile() error {
+	if r.Default != nil {
+		if err := r.Default.compile(); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	for i := range r.Rules {
+		if err := r.Rules[i].compile(); err != nil {
+			name := r.Rules[i].Name
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			return fmt.Errorf("rule %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (r *MockRule) compile() error {
+	var err error
+	if r.Regex != "" {
+		if r.regex, err = regexp.Compile(r.Regex); err != nil {
+			return err
+		}
+	}
+	if r.template, err = template.New("reply").Funcs(mockTemplateFuncs).Parse(r.Reply); err != nil {
+		return err
+	}
+	if r.Error != "" && mockError(r.Error) == nil {
+		return fmt.Errorf("unknown error %q, use an HTTP status, \"timeout\" or \"safety\"", r.Error)
+	}
+	if r.ChunkWords < 0 || r.ErrorAfter < 0 || r.Latency < 0 || r.ChunkDelay < 0 {
+		return errors.New("chunk_words, error_after, latency and chunk_delay can't be negative")
+	}
+	return nil
+}
+
+// match returns the rule answering prompt with its regex submatches, or nil.
+func (r *MockRules) match(prompt string) (*MockRule, []string) {
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		switch {
+		case rule.Match != "":
+			if strings.TrimSpace(prompt) == strings.TrimSpace(rule.Match) {
+				return rule, []string{prompt}
+			}
+		case rule.regex != nil:
+			if groups := rule.regex.FindStringSubmatch(prompt); groups != nil {
+				return rule, groups
+			}
+		default:
+			return rule, []string{prompt}
+		}
+	}
+	if r.Default != nil {
+		return r.Default, []string{prompt}
+	}
+	return nil, nil
+}
+
+// reply renders the rule's answer to the request.
+func (r *MockRule) reply(req Request, prompt string, groups []string) (string, error) {
+	if r.Echo {
+		return prompt, nil
+	}
+
+	data := MockReplyData{
+		Prompt: prompt,
+		Groups: groups,
+		Named:  map[string]string{},
+		Model:  req.Model,
+		System: req.System,
+	}
+	if data.Model == "" {
+		data.Model = "mock"
+	}
+	if r.regex != nil {
+		for i, name := range r.regex.SubexpNames() {
+			if name != "" && i < len(groups) {
+				data.Named[name] = groups[i]
+			}
+		}
+	}
+	for _, message := range req.Messages {
+		if message.Role == "user" {
+			data.Turn++
+			data.Images = len(message.Images)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := r.template.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// mockError builds the error a rule injects, nil for an unknown kind.
+func mockError(kind string) error {
+	switch kind {
+	case "timeout":
+		return fmt.Errorf("mock: simulated timeout: %w", context.DeadlineExceeded)
+	case "safety":
+		return &SafetyError{Provider: "mock", Reason: "simulated"}
+	}
+	status, err := strconv.Atoi(kind)
+	if err != nil || status < 400 || status > 599 {
+		return nil
+	}
+	return &APIError{Provider: "mock", StatusCode: status, Message: "simulated error"}
+}
+
+// mockPacing is how a reply is streamed.
+type mockPacing struct {
+	chunkWords int
+	chunkDelay time.Duration
+	failAfter  int // Chunks sent before fail, when set
+	fail       error
+}
+
+// sleep waits d unless ctx is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// answer runs a matched rule.
+func (m *MockLLM) answer(ctx context.Context, rule *MockRule, req Request, prompt string, groups []string, resp *Response, onDelta func(delta string) error) (*Response, error) {
+	if err := sleep(ctx, time.Duration(rule.Latency)); err != nil {
+		return nil, err
+	}
+
+	var fail error
+	if rule.Error != "" {
+		fail = mockError(rule.Error)
+		if rule.ErrorAfter == 0 {
+			return nil, fail
+		}
+	}
+
+	text, err := rule.reply(req, prompt, groups)
+	if err != nil {
+		return nil, fmt.Errorf("mock rule %s: %w", rule.Name, err)
+	}
+	return m.stream(ctx, req, resp, text, mockPacing{
+		chunkWords: rule.ChunkWords,
+		chunkDelay: time.Duration(rule.ChunkDelay),
+		failAfter:  rule.ErrorAfter,
+		fail:       fail,
+	}, onDelta)
+}
@@ -0,0 +1,44 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMockRuleErrorAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		errorAfter int
+		want       string // Text streamed before the error
+	}{
+		{"mid stream", 2, "one two"},
+		{"after the last chunk", 3, "one two three"},
+		{"past the reply", 10, "one two three"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := &MockRules{Rules: []MockRule{{Reply: "one two three", Error: "500", ErrorAfter: tt.errorAfter}}}
+			if err := rules.compile(); err != nil {
+				t.Fatal(err)
+			}
+			mock := &MockLLM{Rules: rules}
+
+			var streamed strings.Builder
+			resp, err := mock.StreamResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, func(delta string) error {
+				streamed.WriteString(delta)
+				return nil
+			})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+				t.Fatalf("err = %v, want the simulated 500", err)
+			}
+			if streamed.String() != tt.want || resp.Text != tt.want {
+				t.Errorf("streamed %q, response %q, want %q", streamed.String(), resp.Text, tt.want)
+			}
+		})
+	}
+}
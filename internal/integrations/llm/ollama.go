@@ -0,0 +1,366 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/resilience"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const ollamaBaseURL = "http://localhost:11434"
+
+var ErrModelNotPulled = fmt.Errorf("%w: model is not pulled", ErrModelUnavailable)
+
+// OllamaProvider talks to the native Ollama API. Ollama keeps no conversation
+// state, so the whole history is sent on every call.
+type OllamaProvider struct {
+	Model          string
+	EmbeddingModel string
+	BaseURL        string
+	Client         *http.Client
+}
+
+func NewOllamaProvider(model string, baseURL string) *OllamaProvider {
+	if baseURL == "" {
+		baseURL = ollamaBaseURL
+	}
+	return &OllamaProvider{
+		Model:          model,
+		EmbeddingModel: model,
+		BaseURL:        strings.TrimSuffix(baseURL, "/"),
+		Client:         resilience.NewClient("ollama", resilience.ConfigFromEnv("OLLAMA", resilience.DefaultConfig)),
+	}
+}
+
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []Message     `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Options  ollamaOptions `json:"options"`
+}
+
+type ollamaChatResponse struct {
+	Model           string  `json:"model"`
+	CreatedAt       string  `json:"created_at"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+	Error           string  `json:"error"`
+}
+
+// apply adds a chat response, or a chunk of a streamed one, to resp.
+func (r *ollamaChatResponse) apply(resp *Response) {
+	resp.Model = r.Model
+	// Ollama has no response IDs, the creation time is the closest thing.
+	resp.ID = r.CreatedAt
+	if r.Done {
+		resp.FinishReason = FinishStop
+		if r.DoneReason == "length" {
+			resp.FinishReason = FinishLength
+		}
+		resp.Usage = Usage{
+			PromptTokens:     r.PromptEvalCount,
+			CompletionTokens: r.EvalCount,
+			TotalTokens:      r.PromptEvalCount + r.EvalCount,
+		}
+	}
+}
+
+// sameModel tells whether a pulled model name matches the configured one,
+// where a missing tag means "latest".
+func sameModel(pulled string, configured string) bool {
+	if !strings.Contains(configured, ":") {
+		configured += ":latest"
+	}
+	return pulled == configured
+}
+
+func (p *OllamaProvider) model(req Request) string {
+	if req.Model != "" {
+		return req.Model
+	}
+	return p.Model
+}
+
+// do sends a request to the Ollama API. The caller is responsible for closing
+// the response body.
+func (p *OllamaProvider) do(ctx context.Context, method string, path string, body interface{}) (*http.Response, error) {
+	var requestBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		requestBody = bytes.NewBuffer(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.BaseURL+path, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, p.apiError(resp)
+	}
+
+	return resp, nil
+}
+
+func (p *OllamaProvider) apiError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		Error string `json:"error"`
+	}
+	apiErr := &APIError{Provider: "ollama", StatusCode: resp.StatusCode, Message: string(body)}
+	if err := json.Unmarshal(body, &result); err == nil && result.Error != "" {
+		apiErr.Message = result.Error
+	}
+
+	if resp.StatusCode == http.StatusNotFound && strings.Contains(apiErr.Message, "not found") {
+		return fmt.Errorf("%w: %s (run `ollama pull` or set OLLAMA_PULL=true)", ErrModelNotPulled, apiErr.Message)
+	}
+	return apiErr
+}
+
+// GetModels lists the models pulled into Ollama.
+func (p *OllamaProvider) GetModels(ctx context.Context) ([]string, error) {
+	resp, err := p.do(ctx, "GET", "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, len(result.Models))
+	for i, model := range result.Models {
+		models[i] = model.Name
+	}
+
+	return models, nil
+}
+
+// EnsureModel checks that the configured chat and embedding models are
+// pulled, pulling the missing ones when pull is set.
+func (p *OllamaProvider) EnsureModel(ctx context.Context, pull bool) error {
+	models, err := p.GetModels(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, model := range []string{p.Model, p.EmbeddingModel} {
+		if model == "" || slices.ContainsFunc(models, func(pulled string) bool { return sameModel(pulled, model) }) {
+			continue
+		}
+
+		if !pull {
+			return fmt.Errorf("%w: ollama model %q (run `ollama pull %s` or set OLLAMA_PULL=true)", ErrModelNotPulled, model, model)
+		}
+
+		if err := p.pull(ctx, model); err != nil {
+			return fmt.Errorf("pulling ollama model %q: %w", model, err)
+		}
+		models = append(models, model)
+	}
+
+	return nil
+}
+
+// pull downloads a model. Progress is streamed so the request doesn't sit
+// waiting for headers during the whole download.
+func (p *OllamaProvider) pull(ctx context.Context, model string) error {
+	log.Info("Pulling Ollama model: ", model)
+
+	resp, err := p.do(ctx, "POST", "/api/pull", map[string]interface{}{"model": model, "stream": true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var progress struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if err := decoder.Decode(&progress); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if progress.Error != "" {
+			return errors.New(progress.Error)
+		}
+		if progress.Status == "success" {
+			log.Info("Pulled Ollama model: ", model)
+			return nil
+		}
+	}
+}
+
+func (p *OllamaProvider) newRequest(req Request, stream bool) (ollamaChatRequest, error) {
+	turns, err := textOnly("ollama", req.Messages)
+	if err != nil {
+		return ollamaChatRequest{}, err
+	}
+
+	messages := make([]Message, 0, len(turns)+1)
+	if prompt := systemPrompt(req); prompt != "" {
+		messages = append(messages, Message{Role: "system", Content: prompt})
+	}
+	messages = append(messages, withoutTools(turns)...)
+
+	return ollamaChatRequest{
+		Model:    p.model(req),
+		Messages: messages,
+		Stream:   stream,
+		Options: ollamaOptions{
+			Temperature: req.Temperature,
+			TopP:        req.TopP,
+			NumPredict:  req.MaxTokens,
+			Stop:        req.Stop,
+		},
+	}, nil
+}
+
+func (p *OllamaProvider) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	body, err := p.newRequest(req, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.do(ctx, "POST", "/api/chat", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	response := &Response{Text: result.Message.Content}
+	result.apply(response)
+	return response, nil
+}
+
+func (p *OllamaProvider) StreamResponse(ctx context.Context, req Request, onDelta func(delta string) error) (*Response, error) {
+	body, err := p.newRequest(req, true)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.do(ctx, "POST", "/api/chat", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	partial := &Response{}
+	var text strings.Builder
+
+	// Ollama streams one JSON object per line, the last one has done set.
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			partial.Text = text.String()
+			return partial, err
+		}
+		if chunk.Error != "" {
+			partial.Text = text.String()
+			return partial, &APIError{Provider: "ollama", StatusCode: http.StatusInternalServerError, Message: chunk.Error}
+		}
+
+		chunk.apply(partial)
+		if chunk.Message.Content != "" {
+			text.WriteString(chunk.Message.Content)
+			if err := onDelta(chunk.Message.Content); err != nil {
+				partial.Text = text.String()
+				return partial, err
+			}
+		}
+		if chunk.Done {
+			partial.Text = text.String()
+			return partial, nil
+		}
+	}
+
+	partial.Text = text.String()
+	if err := scanner.Err(); err != nil {
+		return partial, err
+	}
+
+	return partial, fmt.Errorf("stream ended unexpectedly")
+}
+
+func (p *OllamaProvider) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	resp, err := p.do(ctx, "POST", "/api/embeddings", map[string]string{
+		"model":  p.EmbeddingModel,
+		"prompt": text,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return normalize(result.Embedding, 0), nil
+}
+
+func (p *OllamaProvider) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedEach(ctx, texts, p.GenerateEmbedding)
+}
+
+func (p *OllamaProvider) DefaultModel() string {
+	return p.Model
+}
+
+func (p *OllamaProvider) EmbeddingModelID() string {
+	return p.EmbeddingModel
+}
@@ -0,0 +1,277 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// ollamaServer serves the given routes, keyed like "POST /api/chat".
+func ollamaServer(t *testing.T, routes map[string]http.HandlerFunc) *OllamaProvider {
+	t.Helper()
+	mux := http.NewServeMux()
+	for pattern, handler := range routes {
+		mux.HandleFunc(pattern, handler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	provider := NewOllamaProvider("llama-test", server.URL)
+	provider.Client = server.Client()
+	return provider
+}
+
+// reply writes body with status, after decoding the request into got when
+// it isn't nil.
+func reply(t *testing.T, status int, body string, got interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if got != nil {
+			if err := json.NewDecoder(r.Body).Decode(got); err != nil {
+				t.Errorf("decode request: %v", err)
+			}
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func TestOllamaRequestMapping(t *testing.T) {
+	temperature := 0.3
+	tests := []struct {
+		name string
+		req  Request
+		want ollamaChatRequest
+	}{
+		{
+			name: "defaults",
+			req:  Request{Messages: []Message{{Role: "user", Content: "hi"}}},
+			want: ollamaChatRequest{
+				Model:    "llama-test",
+				Messages: []Message{{Role: "user", Content: "hi"}},
+			},
+		},
+		{
+			name: "overrides and system prompt",
+			req: Request{
+				Model:       "llama-other",
+				System:      "be brief",
+				Messages:    []Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}, {Role: "user", Content: "bye"}},
+				Temperature: &temperature,
+				MaxTokens:   10,
+				Stop:        []string{"END"},
+			},
+			want: ollamaChatRequest{
+				Model: "llama-other",
+				Messages: []Message{
+					{Role: "system", Content: "be brief"},
+					{Role: "user", Content: "hi"},
+					{Role: "assistant", Content: "hello"},
+					{Role: "user", Content: "bye"},
+				},
+				Options: ollamaOptions{Temperature: &temperature, NumPredict: 10, Stop: []string{"END"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ollamaChatRequest
+			provider := ollamaServer(t, map[string]http.HandlerFunc{
+				"POST /api/chat": reply(t, http.StatusOK, `{"message":{"role":"assistant","content":"ok"},"done":true}`, &got),
+			})
+			if _, err := provider.GenerateResponse(context.Background(), tt.req); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("request = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOllamaGenerateResponse(t *testing.T) {
+	provider := ollamaServer(t, map[string]http.HandlerFunc{
+		"POST /api/chat": reply(t, http.StatusOK, `{
+			"model": "llama-test",
+			"created_at": "2024-01-01T00:00:00Z",
+			"message": {"role": "assistant", "content": "Hello"},
+			"done": true,
+			"done_reason": "length",
+			"prompt_eval_count": 3,
+			"eval_count": 2
+		}`, nil),
+	})
+
+	resp, err := provider.GenerateResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Response{
+		Text:         "Hello",
+		ID:           "2024-01-01T00:00:00Z",
+		FinishReason: FinishLength,
+		Model:        "llama-test",
+		Usage:        Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestOllamaStreamResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		deltas  []string
+		text    string
+		wantErr bool
+	}{
+		{
+			name: "complete",
+			body: `{"model":"llama-test","message":{"role":"assistant","content":"Hel"},"done":false}
+
+{"model":"llama-test","message":{"role":"assistant","content":"lo"},"done":false}
+{"model":"llama-test","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":3,"eval_count":2}
+`,
+			deltas: []string{"Hel", "lo"},
+			text:   "Hello",
+		},
+		{
+			name: "error chunk",
+			body: `{"model":"llama-test","message":{"role":"assistant","content":"Hel"},"done":false}
+{"error":"out of memory"}
+`,
+			deltas:  []string{"Hel"},
+			text:    "Hel",
+			wantErr: true,
+		},
+		{
+			name:    "cut short",
+			body:    `{"model":"llama-test","message":{"role":"assistant","content":"Hel"},"done":false}` + "\n",
+			deltas:  []string{"Hel"},
+			text:    "Hel",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ollamaChatRequest
+			provider := ollamaServer(t, map[string]http.HandlerFunc{
+				"POST /api/chat": reply(t, http.StatusOK, tt.body, &got),
+			})
+
+			var deltas []string
+			resp, err := provider.StreamResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}}, func(delta string) error {
+				deltas = append(deltas, delta)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !got.Stream {
+				t.Error("stream not requested")
+			}
+			if !reflect.DeepEqual(deltas, tt.deltas) {
+				t.Errorf("deltas = %q, want %q", deltas, tt.deltas)
+			}
+			if resp.Text != tt.text {
+				t.Errorf("text = %q, want %q", resp.Text, tt.text)
+			}
+		})
+	}
+}
+
+func TestOllamaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"model not pulled", http.StatusNotFound, `{"error":"model \"llama-test\" not found, try pulling it first"}`, ErrModelNotPulled},
+		{"bad request", http.StatusBadRequest, `{"error":"invalid options"}`, ErrInvalidRequest},
+		{"server error", http.StatusInternalServerError, `{"error":"out of memory"}`, ErrProviderFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := ollamaServer(t, map[string]http.HandlerFunc{
+				"POST /api/chat": reply(t, tt.status, tt.body, nil),
+			})
+			_, err := provider.GenerateResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOllamaModelNotPulledIsRetryable(t *testing.T) {
+	provider := ollamaServer(t, map[string]http.HandlerFunc{
+		"POST /api/chat": reply(t, http.StatusNotFound, `{"error":"model \"llama-test\" not found, try pulling it first"}`, nil),
+	})
+	_, err := provider.GenerateResponse(context.Background(), Request{Messages: []Message{{Role: "user", Content: "hi"}}})
+	if !errors.Is(err, ErrModelUnavailable) || !IsRetryable(err) {
+		t.Fatalf("error = %v, want a retryable %v", err, ErrModelUnavailable)
+	}
+}
+
+func TestOllamaEnsureModel(t *testing.T) {
+	tags := `{"models":[{"name":"other:latest"}]}`
+	tests := []struct {
+		name    string
+		tags    string
+		pull    bool
+		pulled  bool
+		wantErr error
+	}{
+		{name: "already pulled", tags: `{"models":[{"name":"llama-test:latest"}]}`},
+		{name: "missing", tags: tags, wantErr: ErrModelNotPulled},
+		{name: "pulled on demand", tags: tags, pull: true, pulled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pulled := false
+			provider := ollamaServer(t, map[string]http.HandlerFunc{
+				"GET /api/tags": reply(t, http.StatusOK, tt.tags, nil),
+				"POST /api/pull": func(w http.ResponseWriter, r *http.Request) {
+					pulled = true
+					io.WriteString(w, "{\"status\":\"pulling manifest\"}\n{\"status\":\"success\"}\n")
+				},
+			})
+
+			err := provider.EnsureModel(context.Background(), tt.pull)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if pulled != tt.pulled {
+				t.Errorf("pulled = %v, want %v", pulled, tt.pulled)
+			}
+		})
+	}
+}
+
+func TestOllamaGenerateEmbedding(t *testing.T) {
+	var got map[string]string
+	provider := ollamaServer(t, map[string]http.HandlerFunc{
+		"POST /api/embeddings": reply(t, http.StatusOK, `{"embedding":[3,4]}`, &got),
+	})
+
+	embedding, err := provider.GenerateEmbedding(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["model"] != "llama-test" || got["prompt"] != "hello" {
+		t.Errorf("request = %v", got)
+	}
+	if !reflect.DeepEqual(embedding, []float32{0.6, 0.8}) {
+		t.Errorf("embedding = %v, want it normalized", embedding)
+	}
+}
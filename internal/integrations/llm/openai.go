@@ -2,27 +2,45 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/resilience"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/responses"
 )
 
+const openAIEmbeddingModel = "text-embedding-3-small"
+
 type OpenAIProvider struct {
 	ApiKey string
 	Model  string
-	Client *openai.Client
+	// EmbeddingModel and EmbeddingDimensions configure embeddings separately
+	// from chat. Zero dimensions keeps the model's native size.
+	EmbeddingModel      string
+	EmbeddingDimensions int
+	Client              *openai.Client
+	// ServerState continues conversations through previous_response_id.
+	// When false the history in Request.Messages is replayed instead.
+	ServerState bool
 }
 
 func NewOpenAIProvider(apiKey string, model string) *OpenAIProvider {
 	client := openai.NewClient(
 		option.WithAPIKey(apiKey),
+		// Retries are handled by the resilient client.
+		option.WithHTTPClient(resilience.NewClient("openai", resilience.ConfigFromEnv("OPENAI", resilience.DefaultConfig))),
+		option.WithMaxRetries(0),
 	)
 	return &OpenAIProvider{
-		ApiKey: apiKey,
-		Model:  model,
-		Client: &client,
+		ApiKey:         apiKey,
+		Model:          model,
+		EmbeddingModel: openAIEmbeddingModel,
+		Client:         &client,
+		ServerState:    true,
 	}
 }
 
@@ -189,27 +207,272 @@ func NewOpenAIProvider(apiKey string, model string) *OpenAIProvider {
     paramObj
 } */
 
-func (p *OpenAIProvider) GenerateResponse(prompt string, previousID string) (string, string, error) {
+// openaiError converts SDK API errors to APIError, leaving others untouched.
+func openaiError(err error) error {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return &APIError{Provider: "openai", StatusCode: apiErr.StatusCode, Type: apiErr.Type, Message: apiErr.Message}
+	}
+	return err
+}
+
+func (p *OpenAIProvider) newParams(req Request) responses.ResponseNewParams {
+	model := p.Model
+	if req.Model != "" {
+		model = req.Model
+	}
+
+	// With a previous response OpenAI already holds the conversation, so only
+	// the turns after the last assistant reply are sent.
+	messages := req.Messages
+	serverState := p.ServerState && len(req.PreviousID) > 0
+	if serverState {
+		messages = messagesAfterLastReply(messages)
+	}
+
+	input := make(responses.ResponseInputParam, 0, len(messages))
+	for _, message := range messages {
+		switch {
+		case message.Role == "tool":
+			input = append(input, responses.ResponseInputItemParamOfFunctionCallOutput(message.ToolCallID, message.Content))
+			continue
+		case len(message.Images) > 0:
+			input = append(input, responses.ResponseInputItemParamOfMessage(openaiContent(message), responses.EasyInputMessageRole(message.Role)))
+		case message.Content != "" || len(message.ToolCalls) == 0:
+			input = append(input, responses.ResponseInputItemParamOfMessage(message.Content, responses.EasyInputMessageRole(message.Role)))
+		}
+		for _, call := range message.ToolCalls {
+			input = append(input, responses.ResponseInputItemParamOfFunctionCall(call.Arguments, call.ID, call.Name))
+		}
+	}
+
 	params := responses.ResponseNewParams{
-		Input: responses.ResponseNewParamsInputUnion{OfString: openai.String(prompt)},
-		Model: p.Model,
+		Input: responses.ResponseNewParamsInputUnion{OfInputItemList: input},
+		Model: model,
 		Store: openai.Bool(true),
 	}
 
-	if len(previousID) > 0 {
-		params.PreviousResponseID = openai.String(previousID)
+	// Instructions are not carried over through previous_response_id, so
+	// they are sent on every turn.
+	if req.System != "" {
+		params.Instructions = openai.String(req.System)
+	}
+
+	if serverState {
+		params.PreviousResponseID = openai.String(req.PreviousID)
+	}
+
+	if req.Temperature != nil {
+		params.Temperature = openai.Float(*req.Temperature)
+	}
+
+	if req.TopP != nil {
+		params.TopP = openai.Float(*req.TopP)
+	}
+
+	if req.MaxTokens > 0 {
+		params.MaxOutputTokens = openai.Int(int64(req.MaxTokens))
 	}
 
-	resp, err := p.Client.Responses.New(context.TODO(), params)
+	if format := req.ResponseFormat; format != nil {
+		params.Text = responses.ResponseTextConfigParam{
+			Format: responses.ResponseFormatTextConfigUnionParam{
+				OfJSONSchema: &responses.ResponseFormatTextJSONSchemaConfigParam{
+					Name:   format.name(),
+					Schema: format.Schema,
+					Strict: openai.Bool(format.Strict),
+				},
+			},
+		}
+	}
 
+	for _, tool := range req.Tools {
+		function := responses.ToolParamOfFunction(tool.Name, toolParameters(tool), false)
+		if tool.Description != "" {
+			function.OfFunction.Description = openai.String(tool.Description)
+		}
+		params.Tools = append(params.Tools, function)
+	}
+
+	return params
+}
+
+// openaiContent sends a turn with images as input_text and input_image parts.
+func openaiContent(message Message) responses.ResponseInputMessageContentListParam {
+	content := responses.ResponseInputMessageContentListParam{}
+	if message.Content != "" {
+		content = append(content, responses.ResponseInputContentParamOfInputText(message.Content))
+	}
+	for _, image := range message.Images {
+		part := responses.ResponseInputContentParamOfInputImage(responses.ResponseInputImageDetailAuto)
+		part.OfInputImage.ImageURL = openai.String(image.DataURL())
+		content = append(content, part)
+	}
+	return content
+}
+
+// toResponse converts an OpenAI response. The Responses API has no stop
+// sequences, so they are applied here.
+func (p *OpenAIProvider) toResponse(resp *responses.Response, req Request) *Response {
+	text, stopped := truncateAtStop(resp.OutputText(), req.Stop)
+
+	finishReason := FinishStop
+	if !stopped && resp.Status == responses.ResponseStatusIncomplete {
+		switch resp.IncompleteDetails.Reason {
+		case "max_output_tokens":
+			finishReason = FinishLength
+		case "content_filter":
+			finishReason = FinishContentFilter
+		}
+	}
+
+	var toolCalls []ToolCall
+	for _, item := range resp.Output {
+		if item.Type == "function_call" {
+			toolCalls = append(toolCalls, ToolCall{ID: item.CallID, Name: item.Name, Arguments: item.Arguments})
+		}
+	}
+	if len(toolCalls) > 0 {
+		finishReason = FinishToolCalls
+	}
+
+	return &Response{
+		Text:         text,
+		ID:           resp.ID,
+		ToolCalls:    toolCalls,
+		FinishReason: finishReason,
+		Model:        resp.Model,
+		Usage: Usage{
+			PromptTokens:     int(resp.Usage.InputTokens),
+			CompletionTokens: int(resp.Usage.OutputTokens),
+			TotalTokens:      int(resp.Usage.TotalTokens),
+		},
+	}
+}
+
+func (p *OpenAIProvider) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	resp, err := p.Client.Responses.New(ctx, p.newParams(req))
 	if err != nil {
-		panic(err.Error())
+		return nil, openaiError(err)
 	}
 
-	return resp.OutputText(), resp.ID, nil
+	return p.toResponse(resp, req), nil
+}
+
+func (p *OpenAIProvider) StreamResponse(ctx context.Context, req Request, onDelta func(delta string) error) (*Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream := p.Client.Responses.NewStreaming(ctx, p.newParams(req))
+	defer stream.Close()
+
+	partial := &Response{Model: p.Model}
+	filter := stopFilter{stop: req.Stop}
+
+	for stream.Next() {
+		event := stream.Current()
+
+		switch event.Type {
+		case "response.created":
+			partial.ID = event.Response.ID
+		case "response.output_text.delta":
+			delta, stopped := filter.write(event.Delta.OfString)
+			partial.Text += delta
+			if delta != "" {
+				if err := onDelta(delta); err != nil {
+					return partial, err
+				}
+			}
+			if stopped {
+				// Stop reading; the reply we keep is complete.
+				partial.FinishReason = FinishStop
+				return partial, nil
+			}
+		case "response.completed", "response.incomplete":
+			if rest := filter.flush(); rest != "" {
+				if err := onDelta(rest); err != nil {
+					partial.Text += rest
+					return partial, err
+				}
+			}
+			return p.toResponse(&event.Response, req), nil
+		case "response.failed":
+			return partial, fmt.Errorf("openai response failed: %s", event.Response.Error.Message)
+		case "error":
+			return partial, fmt.Errorf("openai stream error: %s", event.Message)
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return partial, openaiError(err)
+	}
+
+	return partial, fmt.Errorf("openai stream ended unexpectedly")
+}
+
+func (p *OpenAIProvider) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	return firstEmbedding(p.GenerateEmbeddings(ctx, []string{text}))
+}
+
+func (p *OpenAIProvider) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedBatches(ctx, texts, p.embed)
+}
+
+func (p *OpenAIProvider) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	params := openai.EmbeddingNewParams{
+		Input:          openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+		Model:          openai.EmbeddingModel(p.EmbeddingModel),
+		EncodingFormat: openai.EmbeddingNewParamsEncodingFormatFloat,
+	}
+	if p.EmbeddingDimensions > 0 {
+		params.Dimensions = openai.Int(int64(p.EmbeddingDimensions))
+	}
+
+	resp, err := p.Client.Embeddings.New(ctx, params)
+	if err != nil {
+		return nil, openaiError(err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("openai returned %d embeddings for %d inputs", len(resp.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, data := range resp.Data {
+		if data.Index < 0 || int(data.Index) >= len(texts) {
+			return nil, fmt.Errorf("openai returned embedding index %d for %d inputs", data.Index, len(texts))
+		}
+		vector := make([]float32, len(data.Embedding))
+		for i, x := range data.Embedding {
+			vector[i] = float32(x)
+		}
+		vectors[data.Index] = normalize(vector, p.EmbeddingDimensions)
+	}
+	return vectors, nil
+}
+
+func (p *OpenAIProvider) DefaultModel() string {
+	return p.Model
+}
+
+// openAINonTextModels marks models the chat and embedding APIs can't use.
+var openAINonTextModels = []string{"dall-e", "whisper", "tts", "audio", "realtime", "image", "moderation", "transcribe", "davinci", "babbage"}
+
+// GetModels lists the text models available to the API key.
+func (p *OpenAIProvider) GetModels(ctx context.Context) ([]string, error) {
+	var models []string
+	pager := p.Client.Models.ListAutoPaging(ctx)
+	for pager.Next() {
+		id := pager.Current().ID
+		if !slices.ContainsFunc(openAINonTextModels, func(marker string) bool { return strings.Contains(id, marker) }) {
+			models = append(models, id)
+		}
+	}
+	if err := pager.Err(); err != nil {
+		return nil, openaiError(err)
+	}
+	return models, nil
 }
 
-func (p *OpenAIProvider) GenerateEmbedding(text string) ([]float32, error) {
-	// TODO: Implement OpenAI embedding API
-	return nil, fmt.Errorf("openai embedding not implemented")
+func (p *OpenAIProvider) EmbeddingModelID() string {
+	return embeddingModelID(p.EmbeddingModel, p.EmbeddingDimensions)
 }
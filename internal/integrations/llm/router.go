@@ -0,0 +1,275 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+const (
+	defaultFailureThreshold = 3
+	defaultCooldown         = 30 * time.Second
+)
+
+// Backend is a named provider behind a Router.
+type Backend struct {
+	Name     string
+	Provider LLMProvider
+	Weight   int // Share of the traffic in weighted mode
+
+	failures       int
+	unhealthyUntil time.Time
+	lastError      string
+}
+
+// BackendHealth is a snapshot of a backend's state.
+type BackendHealth struct {
+	Name           string     `json:"name"`
+	Healthy        bool       `json:"healthy"`
+	Failures       int        `json:"consecutive_failures"`
+	UnhealthyUntil *time.Time `json:"unhealthy_until,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// ModelSource tells whether a backend serves a model. Known is false when
+// the backend's models couldn't be listed.
+type ModelSource interface {
+	Serves(backend string, model string) (serves bool, known bool)
+}
+
+// Router is an LLMProvider spreading calls over several backends. Calls go to
+// the first healthy backend in order, or to one picked by weight when Weighted
+// is set, and fail over to the next one on retryable errors. A backend that
+// fails FailureThreshold times in a row is skipped for Cooldown.
+type Router struct {
+	Backends         []*Backend
+	Weighted         bool
+	FailureThreshold int
+	Cooldown         time.Duration
+	// Models tells which backends serve the model a request names; the
+	// others get the request with their default model. Without it the model
+	// is only sent to the first backend tried.
+	Models ModelSource
+
+	mu sync.Mutex
+}
+
+func NewRouter(backends []*Backend, weighted bool) *Router {
+	return &Router{
+		Backends:         backends,
+		Weighted:         weighted,
+		FailureThreshold: defaultFailureThreshold,
+		Cooldown:         defaultCooldown,
+	}
+}
+
+// order returns the backends in the order they should be tried. Unhealthy
+// backends go last, so they are only used when nothing else works.
+func (r *Router) order() []*Backend {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]*Backend, 0, len(r.Backends))
+	unhealthy := []*Backend{}
+	for _, backend := range r.Backends {
+		if now.Before(backend.unhealthyUntil) {
+			unhealthy = append(unhealthy, backend)
+		} else {
+			healthy = append(healthy, backend)
+		}
+	}
+
+	if r.Weighted && len(healthy) > 1 {
+		total := 0
+		for _, backend := range healthy {
+			total += max(backend.Weight, 0)
+		}
+		if total > 0 {
+			pick := rand.IntN(total)
+			for i, backend := range healthy {
+				if pick < max(backend.Weight, 0) {
+					// Move the picked backend first, keep the rest as failover.
+					copy(healthy[1:i+1], healthy[:i])
+					healthy[0] = backend
+					break
+				}
+				pick -= max(backend.Weight, 0)
+			}
+		}
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+func (r *Router) succeeded(backend *Backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	backend.failures = 0
+	backend.unhealthyUntil = time.Time{}
+}
+
+func (r *Router) failed(backend *Backend, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	backend.failures++
+	backend.lastError = err.Error()
+	if backend.failures >= r.FailureThreshold {
+		backend.unhealthyUntil = time.Now().Add(r.Cooldown)
+		log.Warn("LLM backend ", backend.Name, " marked unhealthy for ", r.Cooldown, ": ", err)
+	}
+}
+
+// Health returns the state of every backend.
+func (r *Router) Health() []BackendHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	health := make([]BackendHealth, len(r.Backends))
+	for i, backend := range r.Backends {
+		health[i] = BackendHealth{
+			Name:      backend.Name,
+			Healthy:   !now.Before(backend.unhealthyUntil),
+			Failures:  backend.failures,
+			LastError: backend.lastError,
+		}
+		if !health[i].Healthy {
+			until := backend.unhealthyUntil
+			health[i].UnhealthyUntil = &until
+		}
+	}
+	return health
+}
+
+// route runs call against the backends until one succeeds or fails with an
+// error that another backend wouldn't fix. canFailover is checked after a
+// failure, streaming calls use it to stop once text reached the client.
+func (r *Router) route(ctx context.Context, req Request, call func(backend *Backend, req Request) (*Response, error), canFailover func() bool) (*Response, error) {
+	var failovers []string
+	var resp *Response
+	var err error
+
+	backends := r.order()
+	if req.Provider != "" {
+		// A pinned request only goes to its backend, healthy or not.
+		backends = slices.DeleteFunc(backends, func(backend *Backend) bool { return backend.Name != req.Provider })
+		if len(backends) == 0 {
+			return nil, fmt.Errorf("%w: unknown LLM backend %q", ErrInvalidRequest, req.Provider)
+		}
+	}
+
+	for i, backend := range backends {
+		resp, err = call(backend, r.requestFor(backend, req, i == 0))
+		if resp != nil {
+			resp.Provider = backend.Name
+			resp.Failovers = failovers
+		}
+		if err == nil {
+			r.succeeded(backend)
+			if len(failovers) > 0 {
+				log.Warn("LLM request served by ", backend.Name, " after failover: ", failovers)
+			}
+			return resp, nil
+		}
+
+		if !IsRetryable(err) || ctx.Err() != nil {
+			return resp, err
+		}
+
+		r.failed(backend, err)
+		if !canFailover() {
+			return resp, err
+		}
+
+		log.Warn("LLM backend ", backend.Name, " failed, failing over: ", err)
+		failovers = append(failovers, fmt.Sprintf("%s: %v", backend.Name, err))
+	}
+
+	return resp, fmt.Errorf("all LLM backends failed: %w", err)
+}
+
+// requestFor adapts the request to a backend. A previous response ID only
+// means something to the backend that created it, and a model to the
+// backends serving it: the others fall back to their default model.
+func (r *Router) requestFor(backend *Backend, req Request, first bool) Request {
+	if req.PreviousProvider != "" && req.PreviousProvider != backend.Name {
+		req.PreviousID = ""
+	}
+	if req.Model != "" && !r.serves(backend, req.Model, first) {
+		req.Model = ""
+	}
+	return req
+}
+
+// serves tells whether backend serves model. When that's unknown, the model
+// is assumed to be meant for the first backend tried.
+func (r *Router) serves(backend *Backend, model string, first bool) bool {
+	if r.Models != nil {
+		if serves, known := r.Models.Serves(backend.Name, model); known {
+			return serves
+		}
+	}
+	return first
+}
+
+func (r *Router) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	return r.route(ctx, req, func(backend *Backend, req Request) (*Response, error) {
+		return backend.Provider.GenerateResponse(ctx, req)
+	}, func() bool { return true })
+}
+
+func (r *Router) StreamResponse(ctx context.Context, req Request, onDelta func(delta string) error) (*Response, error) {
+	streamed := false
+	return r.route(ctx, req, func(backend *Backend, req Request) (*Response, error) {
+		return backend.Provider.StreamResponse(ctx, req, func(delta string) error {
+			streamed = true
+			return onDelta(delta)
+		})
+	}, func() bool {
+		// Once part of an answer was sent, switching backends would mix two
+		// different answers.
+		return !streamed
+	})
+}
+
+// GenerateEmbedding always uses the first backend: vectors from different
+// embedding models can't be compared, so failing over would silently corrupt
+// any index built with them.
+func (r *Router) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if len(r.Backends) == 0 {
+		return nil, errors.New("no LLM backends configured")
+	}
+	return r.Backends[0].Provider.GenerateEmbedding(ctx, text)
+}
+
+func (r *Router) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(r.Backends) == 0 {
+		return nil, errors.New("no LLM backends configured")
+	}
+	return r.Backends[0].Provider.GenerateEmbeddings(ctx, texts)
+}
+
+// DefaultModel is the first backend's model, the one requests go to while
+// it's healthy.
+func (r *Router) DefaultModel() string {
+	if len(r.Backends) == 0 {
+		return ""
+	}
+	return r.Backends[0].Provider.DefaultModel()
+}
+
+func (r *Router) EmbeddingModelID() string {
+	if len(r.Backends) == 0 {
+		return ""
+	}
+	return r.Backends[0].Provider.EmbeddingModelID()
+}
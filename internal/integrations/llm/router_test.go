@@ -0,0 +1,85 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// recordingProvider answers with err, or with its model, and keeps the last
+// request.
+type recordingProvider struct {
+	MockLLM
+	model string
+	err   error
+	got   *Request
+}
+
+func (p *recordingProvider) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	p.got = &req
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &Response{Text: "ok", Model: p.model}, nil
+}
+
+func (p *recordingProvider) DefaultModel() string {
+	return p.model
+}
+
+func TestRouterFailoverModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog bool
+		model   string
+		want    string // Model the failover backend gets
+	}{
+		{"default model", true, "", ""},
+		{"model of the failed backend", true, "model-a", ""},
+		{"model served by both", true, "shared", "shared"},
+		{"no catalog", false, "model-a", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &recordingProvider{model: "model-a", err: &APIError{Provider: "a", StatusCode: http.StatusServiceUnavailable}}
+			b := &recordingProvider{model: "model-b"}
+			router := NewRouter([]*Backend{{Name: "a", Provider: a, Weight: 1}, {Name: "b", Provider: b, Weight: 1}}, false)
+			if tt.catalog {
+				catalog := NewCatalog(router)
+				for _, backend := range catalog.backends {
+					backend.listed = true
+					backend.models = append(backend.models, ModelInfo{ID: "shared", Provider: backend.name, Chat: true})
+				}
+			}
+
+			resp, err := router.GenerateResponse(context.Background(), Request{Model: tt.model})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Provider != "b" {
+				t.Fatalf("served by %q, want b", resp.Provider)
+			}
+			if a.got.Model != tt.model {
+				t.Errorf("first backend got model %q, want %q", a.got.Model, tt.model)
+			}
+			if b.got.Model != tt.want {
+				t.Errorf("failover backend got model %q, want %q", b.got.Model, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatalogSnapshotBackendHealth(t *testing.T) {
+	router := NewRouter([]*Backend{{Name: "a", Provider: &MockLLM{}}, {Name: "b", Provider: &MockLLM{}}}, false)
+	router.FailureThreshold = 1
+	router.failed(router.Backends[1], &APIError{Provider: "b", StatusCode: http.StatusInternalServerError, Message: "down"})
+
+	snapshot := NewCatalog(router).Snapshot()
+	if len(snapshot.Backends) != 2 {
+		t.Fatalf("backends = %+v", snapshot.Backends)
+	}
+	if !snapshot.Backends[0].Healthy || snapshot.Backends[1].Healthy {
+		t.Errorf("health = %+v, want a healthy and b not", snapshot.Backends)
+	}
+}
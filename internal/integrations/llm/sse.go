@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// readEvents parses a server-sent events stream and calls onEvent for every
+// event with its name and data. onEvent returns io.EOF once the provider
+// signals the end of the stream; any other error stops reading and is
+// returned.
+func readEvents(r io.Reader, onEvent func(event string, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			if len(data) > 0 {
+				if err := onEvent(event, strings.Join(data, "\n")); err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+			}
+			event, data = "", nil
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if err := onEvent(event, strings.Join(data, "\n")); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	// The stream ended before the provider said it was done.
+	return io.ErrUnexpectedEOF
+}
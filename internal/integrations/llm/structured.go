@@ -0,0 +1,159 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/LDTorres/golang-chat-ai/internal/jsonschema"
+)
+
+// ResponseFormat asks for a reply that is a JSON value matching Schema.
+// OpenAI enforces it natively; the other providers are instructed through
+// the system prompt, and GenerateStructured repairs what they get wrong.
+type ResponseFormat struct {
+	Name   string // Identifies the schema to the provider, "response" by default
+	Schema map[string]interface{}
+	// Strict makes OpenAI guarantee the schema. It only accepts schemas
+	// where every object lists all properties as required and disallows
+	// additional ones.
+	Strict bool
+}
+
+func (f *ResponseFormat) name() string {
+	if f.Name == "" {
+		return "response"
+	}
+	return f.Name
+}
+
+const DefaultStructuredRepairs = 2
+
+// SchemaError reports an invalid schema; nothing was generated.
+type SchemaError struct {
+	Errors []jsonschema.Error
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("invalid schema: %s", joinErrors(e.Errors))
+}
+
+// ValidationError reports a reply that still didn't match the schema after
+// the allowed repairs.
+type ValidationError struct {
+	Errors   []jsonschema.Error
+	Raw      string // Last reply as received
+	Attempts int
+	Response *Response // Last reply, with the usage of every attempt
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("reply does not match the schema after %d attempts: %s", e.Attempts, joinErrors(e.Errors))
+}
+
+type StructuredResponse struct {
+	*Response
+	Data     json.RawMessage // The validated JSON value
+	Attempts int
+}
+
+// systemPrompt returns the system instructions, followed by the schema the
+// reply must follow for providers without native structured outputs.
+func systemPrompt(req Request) string {
+	if req.ResponseFormat == nil {
+		return req.System
+	}
+
+	schema, _ := json.Marshal(req.ResponseFormat.Schema)
+	instructions := "Reply with a single JSON value that matches this JSON Schema, without any other text or code fences:\n" + string(schema)
+	if req.System == "" {
+		return instructions
+	}
+	return req.System + "\n\n" + instructions
+}
+
+// GenerateStructured generates a JSON reply for req.ResponseFormat and
+// validates it. Invalid replies are sent back to the model with the
+// validation errors, up to repairs times.
+func GenerateStructured(ctx context.Context, provider LLMProvider, req Request, repairs int) (*StructuredResponse, error) {
+	if req.ResponseFormat == nil {
+		return nil, fmt.Errorf("%w: no response format", ErrInvalidRequest)
+	}
+	schema := req.ResponseFormat.Schema
+	if errs := jsonschema.Check(schema); len(errs) > 0 {
+		return nil, &SchemaError{Errors: errs}
+	}
+
+	// Repairs replay the conversation, so provider side state isn't used.
+	req.PreviousID = ""
+	req.Messages = append([]Message(nil), req.Messages...)
+
+	var usage Usage
+	for attempt := 1; ; attempt++ {
+		resp, err := provider.GenerateResponse(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		usage.PromptTokens += resp.Usage.PromptTokens
+		usage.CompletionTokens += resp.Usage.CompletionTokens
+		usage.TotalTokens += resp.Usage.TotalTokens
+
+		data, errs := parseStructured(resp.Text, schema)
+		if len(errs) == 0 {
+			resp.Usage = usage
+			return &StructuredResponse{Response: resp, Data: data, Attempts: attempt}, nil
+		}
+		if attempt > repairs {
+			resp.Usage = usage
+			return nil, &ValidationError{Errors: errs, Raw: resp.Text, Attempts: attempt, Response: resp}
+		}
+
+		req.Messages = append(req.Messages,
+			Message{Role: "assistant", Content: resp.Text},
+			Message{Role: "user", Content: "Your reply does not match the JSON Schema: " + joinErrors(errs) +
+				". Reply again with only the corrected JSON value."},
+		)
+	}
+}
+
+// parseStructured extracts the JSON value from a reply, tolerating code
+// fences and text around it, and validates it.
+func parseStructured(text string, schema map[string]interface{}) (json.RawMessage, []jsonschema.Error) {
+	text = strings.TrimSpace(text)
+	if fenced, ok := strings.CutPrefix(text, "```"); ok {
+		if newline := strings.IndexByte(fenced, '\n'); newline >= 0 {
+			fenced = fenced[newline+1:]
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(fenced), "```"))
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		start := strings.IndexAny(text, "{[")
+		end := strings.LastIndexAny(text, "}]")
+		if start < 0 || end < start || json.Unmarshal([]byte(text[start:end+1]), &value) != nil {
+			return nil, []jsonschema.Error{{Message: "reply is not valid JSON: " + err.Error()}}
+		}
+		text = text[start : end+1]
+	}
+
+	if errs := jsonschema.Validate(schema, value); len(errs) > 0 {
+		return nil, errs
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(text)); err != nil {
+		return nil, []jsonschema.Error{{Message: err.Error()}}
+	}
+	return compact.Bytes(), nil
+}
+
+func joinErrors(errs []jsonschema.Error) string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
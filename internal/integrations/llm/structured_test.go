@@ -0,0 +1,127 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var personSchema = map[string]interface{}{
+	"type":       "object",
+	"properties": map[string]interface{}{"name": map[string]interface{}{"type": "string"}},
+	"required":   []interface{}{"name"},
+}
+
+func TestParseStructured(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+		err   string
+	}{
+		{"plain", `{"name": "Ada"}`, `{"name":"Ada"}`, ""},
+		{"code fence", "```json\n{\"name\": \"Ada\"}\n```", `{"name":"Ada"}`, ""},
+		{"bare fence", "```\n{\"name\": \"Ada\"}\n```", `{"name":"Ada"}`, ""},
+		{"text around", "Here it is: {\"name\": \"Ada\"} Hope it helps.", `{"name":"Ada"}`, ""},
+		{"not JSON", "I don't know", "", "reply is not valid JSON"},
+		{"schema mismatch", `{"name": 1}`, "", "/name: expected string, got integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, errs := parseStructured(tt.reply, personSchema)
+			if tt.err != "" {
+				if len(errs) == 0 || !strings.Contains(joinErrors(errs), tt.err) {
+					t.Errorf("errors = %v, want %q", errs, tt.err)
+				}
+				return
+			}
+			if len(errs) > 0 || string(data) != tt.want {
+				t.Errorf("parseStructured = %s, %v, want %s", data, errs, tt.want)
+			}
+		})
+	}
+}
+
+// countingProvider counts the calls made to the mock it wraps.
+type countingProvider struct {
+	*MockLLM
+	calls int
+}
+
+func (p *countingProvider) GenerateResponse(ctx context.Context, req Request) (*Response, error) {
+	p.calls++
+	return p.MockLLM.GenerateResponse(ctx, req)
+}
+
+func structuredMock(t *testing.T, rules ...MockRule) *countingProvider {
+	t.Helper()
+	mock := &MockRules{Rules: rules}
+	if err := mock.compile(); err != nil {
+		t.Fatal(err)
+	}
+	return &countingProvider{MockLLM: &MockLLM{Rules: mock}}
+}
+
+func TestGenerateStructuredRepairs(t *testing.T) {
+	provider := structuredMock(t,
+		MockRule{Regex: "does not match", Reply: `{"name": "Ada"}`},
+		MockRule{Reply: `{"name": 1}`},
+	)
+	req := Request{
+		Messages:       []Message{{Role: "user", Content: "Who wrote the first program?"}},
+		ResponseFormat: &ResponseFormat{Schema: personSchema},
+	}
+
+	resp, err := GenerateStructured(context.Background(), provider, req, DefaultStructuredRepairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Data) != `{"name":"Ada"}` || resp.Attempts != 2 || provider.calls != 2 {
+		t.Errorf("data %s after %d attempts and %d calls, want the repaired reply after 2", resp.Data, resp.Attempts, provider.calls)
+	}
+}
+
+func TestGenerateStructuredStopsAfterMaxRepairs(t *testing.T) {
+	provider := structuredMock(t, MockRule{Reply: `{"name": 1}`})
+	req := Request{
+		Messages:       []Message{{Role: "user", Content: "Who wrote the first program?"}},
+		ResponseFormat: &ResponseFormat{Schema: personSchema},
+	}
+
+	for _, repairs := range []int{0, 1, 3} {
+		provider.calls = 0
+		_, err := GenerateStructured(context.Background(), provider, req, repairs)
+
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("%d repairs: err = %v, want a ValidationError", repairs, err)
+		}
+		if validationErr.Attempts != repairs+1 || provider.calls != repairs+1 {
+			t.Errorf("%d repairs: %d attempts and %d calls, want %d", repairs, validationErr.Attempts, provider.calls, repairs+1)
+		}
+		if validationErr.Raw != `{"name": 1}` || validationErr.Errors[0].Path != "/name" {
+			t.Errorf("%d repairs: raw %q, errors %v", repairs, validationErr.Raw, validationErr.Errors)
+		}
+		if validationErr.Response.Usage.CompletionTokens != 2*(repairs+1) {
+			t.Errorf("%d repairs: usage %+v doesn't add up every attempt", repairs, validationErr.Response.Usage)
+		}
+	}
+}
+
+func TestGenerateStructuredRejectsBadSchema(t *testing.T) {
+	provider := structuredMock(t)
+	req := Request{
+		Messages:       []Message{{Role: "user", Content: "Hi"}},
+		ResponseFormat: &ResponseFormat{Schema: map[string]interface{}{"type": "text"}},
+	}
+
+	var schemaErr *SchemaError
+	if _, err := GenerateStructured(context.Background(), provider, req, 1); !errors.As(err, &schemaErr) {
+		t.Fatalf("err = %v, want a SchemaError", err)
+	}
+	if provider.calls != 0 {
+		t.Errorf("%d calls were made with an invalid schema", provider.calls)
+	}
+}
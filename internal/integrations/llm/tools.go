@@ -0,0 +1,41 @@
+package llm
+
+// Tool describes a function the model may call. Parameters is the JSON
+// Schema of its arguments object.
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// ToolCall is a function call requested by the model. Arguments is the
+// JSON object the model produced, which isn't guaranteed to be valid.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// withoutTools drops the tool calls and results from the history, for
+// providers that don't support tools yet. Assistant text written alongside a
+// call is kept.
+func withoutTools(messages []Message) []Message {
+	plain := make([]Message, 0, len(messages))
+	for _, message := range messages {
+		if message.Role == "tool" || (len(message.ToolCalls) > 0 && message.Content == "") {
+			continue
+		}
+		message.ToolCalls = nil
+		plain = append(plain, message)
+	}
+	return plain
+}
+
+// toolParameters defaults missing parameters to an empty object, which every
+// provider requires.
+func toolParameters(tool Tool) map[string]interface{} {
+	if tool.Parameters == nil {
+		return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	return tool.Parameters
+}
@@ -6,14 +6,32 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/resilience"
 )
 
+// defaultConfig is tighter than the LLM one: vector calls are expected to
+// answer quickly.
+var defaultConfig = resilience.Config{
+	Timeout:          10 * time.Second,
+	MaxRetries:       3,
+	BaseDelay:        200 * time.Millisecond,
+	MaxDelay:         5 * time.Second,
+	FailureThreshold: 5,
+	OpenTimeout:      15 * time.Second,
+}
+
 type QdrantClient struct {
 	BaseURL string
+	Client  *http.Client
 }
 
 func NewQdrantClient(baseURL string) *QdrantClient {
-	return &QdrantClient{BaseURL: baseURL}
+	return &QdrantClient{
+		BaseURL: baseURL,
+		Client:  resilience.NewClient("qdrant", resilience.ConfigFromEnv("QDRANT", defaultConfig)),
+	}
 }
 
 func (c *QdrantClient) CreateCollection(name string, vectorSize int) error {
@@ -29,7 +47,7 @@ func (c *QdrantClient) CreateCollection(name string, vectorSize int) error {
 	req, _ := http.NewRequest("PUT", url, bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.Client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -52,7 +70,7 @@ func (c *QdrantClient) UpsertPoints(collectionName string, points []map[string]i
 	req, _ := http.NewRequest("PUT", url, bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.Client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -77,7 +95,7 @@ func (c *QdrantClient) Search(collectionName string, vector []float32, limit int
 	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.Client.Do(req)
 	if err != nil {
 		return nil, err
 	}
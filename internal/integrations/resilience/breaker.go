@@ -0,0 +1,146 @@
+package resilience
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the backend while its circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type State string
+
+const (
+	StateClosed   State = "closed"    // Calls go through
+	StateOpen     State = "open"      // Calls fail fast
+	StateHalfOpen State = "half_open" // A single probe call is let through
+)
+
+// Breaker stops calling a backend after FailureThreshold consecutive
+// failures. Once OpenTimeout has passed it lets one probe through: success
+// closes the circuit again, failure keeps it open for another OpenTimeout.
+type Breaker struct {
+	Name             string
+	FailureThreshold int
+	OpenTimeout      time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewBreaker(name string, failureThreshold int, openTimeout time.Duration) *Breaker {
+	return &Breaker{
+		Name:             name,
+		FailureThreshold: failureThreshold,
+		OpenTimeout:      openTimeout,
+		state:            StateClosed,
+	}
+}
+
+// Allow reports whether a call may be made now.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.OpenTimeout {
+			return false
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+		return true
+	case StateHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = StateClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == StateHalfOpen || b.failures >= b.FailureThreshold {
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Abandon releases a call that ended without telling anything about the
+// backend, such as one cancelled by the caller.
+func (b *Breaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.OpenTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+var breakers = struct {
+	sync.Mutex
+	byName map[string]*Breaker
+}{byName: map[string]*Breaker{}}
+
+// register makes the breaker visible through States. Clients sharing a name
+// share the breaker.
+func register(b *Breaker) *Breaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	if existing, ok := breakers.byName[b.Name]; ok {
+		return existing
+	}
+	breakers.byName[b.Name] = b
+	return b
+}
+
+// BreakerState is the state of one integration's circuit breaker.
+type BreakerState struct {
+	Name  string `json:"name"`
+	State State  `json:"state"`
+}
+
+// States returns the state of every registered circuit breaker, sorted by
+// name.
+func States() []BreakerState {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	states := make([]BreakerState, 0, len(breakers.byName))
+	for name, b := range breakers.byName {
+		states = append(states, BreakerState{Name: name, State: b.State()})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
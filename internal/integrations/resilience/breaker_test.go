@@ -0,0 +1,61 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	breaker := NewBreaker("test", 3, 20*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		breaker.Failure()
+	}
+	if !breaker.Allow() || breaker.State() != StateClosed {
+		t.Fatalf("state = %s below the threshold, want closed", breaker.State())
+	}
+	breaker.Failure()
+	if breaker.Allow() || breaker.State() != StateOpen {
+		t.Fatalf("state = %s after 3 failures, want open", breaker.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if breaker.State() != StateHalfOpen {
+		t.Fatalf("state = %s after the cooldown, want half open", breaker.State())
+	}
+	if !breaker.Allow() {
+		t.Fatal("the probe was refused")
+	}
+	if breaker.Allow() {
+		t.Error("a second call went through while probing")
+	}
+
+	// A failed probe opens the circuit for another cooldown.
+	breaker.Failure()
+	if breaker.Allow() || breaker.State() != StateOpen {
+		t.Fatalf("state = %s after a failed probe, want open", breaker.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("the probe was refused")
+	}
+	breaker.Success()
+	if breaker.State() != StateClosed || !breaker.Allow() || !breaker.Allow() {
+		t.Errorf("state = %s after a successful probe, want closed", breaker.State())
+	}
+}
+
+func TestBreakerAbandonedProbe(t *testing.T) {
+	breaker := NewBreaker("test", 1, time.Millisecond)
+	breaker.Failure()
+	time.Sleep(5 * time.Millisecond)
+
+	if !breaker.Allow() {
+		t.Fatal("the probe was refused")
+	}
+	breaker.Abandon()
+	if !breaker.Allow() {
+		t.Error("an abandoned probe wasn't released")
+	}
+}
@@ -0,0 +1,183 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config tunes the resilient client of one integration.
+type Config struct {
+	// Timeout bounds the wait for the response headers of each attempt. It
+	// doesn't limit reading the body, so long streams are not cut off.
+	Timeout    time.Duration
+	MaxRetries int
+	BaseDelay  time.Duration // First backoff, doubled on each retry
+	MaxDelay   time.Duration // Cap for backoff and Retry-After waits
+	// Consecutive failed calls, after retries, that open the circuit.
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+// DefaultConfig suits LLM APIs: without streaming the headers only arrive
+// once the whole answer is generated, hence the long timeout.
+var DefaultConfig = Config{
+	Timeout:          2 * time.Minute,
+	MaxRetries:       2,
+	BaseDelay:        500 * time.Millisecond,
+	MaxDelay:         30 * time.Second,
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+}
+
+// ConfigFromEnv reads the configuration of an integration from variables
+// named after prefix, e.g. OPENAI_HTTP_TIMEOUT, OPENAI_HTTP_RETRIES,
+// OPENAI_BREAKER_THRESHOLD and OPENAI_BREAKER_TIMEOUT, falling back to
+// defaults.
+func ConfigFromEnv(prefix string, defaults Config) Config {
+	config := defaults
+
+	if timeout, err := time.ParseDuration(os.Getenv(prefix + "_HTTP_TIMEOUT")); err == nil {
+		config.Timeout = timeout
+	}
+	if retries, err := strconv.Atoi(os.Getenv(prefix + "_HTTP_RETRIES")); err == nil && retries >= 0 {
+		config.MaxRetries = retries
+	}
+	if threshold, err := strconv.Atoi(os.Getenv(prefix + "_BREAKER_THRESHOLD")); err == nil && threshold > 0 {
+		config.FailureThreshold = threshold
+	}
+	if openTimeout, err := time.ParseDuration(os.Getenv(prefix + "_BREAKER_TIMEOUT")); err == nil {
+		config.OpenTimeout = openTimeout
+	}
+
+	return config
+}
+
+// NewClient returns an http.Client that retries failed requests with jittered
+// exponential backoff and guards the backend with a circuit breaker named
+// after the integration.
+func NewClient(name string, config Config) *http.Client {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.ResponseHeaderTimeout = config.Timeout
+
+	return &http.Client{
+		Transport: &Transport{
+			Base:    base,
+			Config:  config,
+			Breaker: register(NewBreaker(name, config.FailureThreshold, config.OpenTimeout)),
+		},
+	}
+}
+
+// Transport is the http.RoundTripper behind NewClient.
+type Transport struct {
+	Base    http.RoundTripper
+	Config  Config
+	Breaker *Breaker
+}
+
+// retryable tells whether a response status is worth retrying.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout ||
+		status == http.StatusInternalServerError
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.Breaker.Allow() {
+		return nil, fmt.Errorf("%s: %w", t.Breaker.Name, ErrCircuitOpen)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("%s: request body can't be replayed for a retry", t.Breaker.Name)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		resp, err := t.Base.RoundTrip(req)
+
+		failed := err != nil || retryable(resp.StatusCode)
+		if !failed {
+			t.Breaker.Success()
+			return resp, nil
+		}
+
+		// The caller gave up, which says nothing about the backend.
+		if req.Context().Err() != nil {
+			t.Breaker.Abandon()
+			return resp, err
+		}
+
+		if attempt >= t.Config.MaxRetries {
+			t.Breaker.Failure()
+			return resp, err
+		}
+
+		delay := t.backoff(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), delay); err != nil {
+			t.Breaker.Abandon()
+			return nil, err
+		}
+	}
+}
+
+// backoff returns the wait before the next attempt: the server's Retry-After
+// when it sent one, otherwise exponential backoff with full jitter.
+func (t *Transport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return min(delay, t.Config.MaxDelay)
+		}
+	}
+
+	ceiling := min(t.Config.BaseDelay<<attempt, t.Config.MaxDelay)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as a date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,159 @@
+package resilience
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConfig retries quickly and never opens the circuit on its own.
+var testConfig = Config{
+	MaxRetries:       2,
+	BaseDelay:        time.Millisecond,
+	MaxDelay:         10 * time.Millisecond,
+	FailureThreshold: 100,
+	OpenTimeout:      time.Hour,
+}
+
+// newTestClient returns a client for a server answering with the given
+// statuses in turn, then with the last one, and the number of calls it got.
+func newTestClient(t *testing.T, config Config, statuses ...int) (*http.Client, string, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		w.WriteHeader(statuses[min(call, len(statuses))-1])
+	}))
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: &Transport{
+		Base:    http.DefaultTransport,
+		Config:  config,
+		Breaker: NewBreaker(t.Name(), config.FailureThreshold, config.OpenTimeout),
+	}}
+	return client, server.URL, &calls
+}
+
+func TestRetryableStatuses(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int // Final status
+		calls    int32
+	}{
+		{"recovers", []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK}, http.StatusOK, 3},
+		{"gives up after the retries", []int{http.StatusServiceUnavailable}, http.StatusServiceUnavailable, 3},
+		{"rate limited", []int{http.StatusTooManyRequests}, http.StatusTooManyRequests, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, url, calls := newTestClient(t, testConfig, tt.statuses...)
+			resp, err := client.Post(url, "text/plain", strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if calls.Load() != tt.calls {
+				t.Errorf("calls = %d, want %d", calls.Load(), tt.calls)
+			}
+		})
+	}
+}
+
+func TestNonRetryableStatuses(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusUnprocessableEntity} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			client, url, calls := newTestClient(t, testConfig, status)
+			resp, err := client.Get(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != status || calls.Load() != 1 {
+				t.Errorf("status %d after %d calls, want %d after 1", resp.StatusCode, calls.Load(), status)
+			}
+		})
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	config := testConfig
+	config.MaxDelay = 50 * time.Millisecond
+	client := &http.Client{Transport: &Transport{Base: http.DefaultTransport, Config: config, Breaker: NewBreaker(t.Name(), 100, time.Hour)}}
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Fatalf("status %d after %d calls, want 200 after 2", resp.StatusCode, calls.Load())
+	}
+	if elapsed < config.MaxDelay || elapsed > time.Second {
+		t.Errorf("waited %v, want MaxDelay (%v)", elapsed, config.MaxDelay)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	transport := &Transport{Config: Config{BaseDelay: 100 * time.Millisecond, MaxDelay: 10 * time.Second}}
+	withRetryAfter := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{value}}}
+	}
+
+	if got := transport.backoff(0, withRetryAfter("2")); got != 2*time.Second {
+		t.Errorf("Retry-After in seconds: waited %v, want 2s", got)
+	}
+	if got := transport.backoff(0, withRetryAfter("60")); got != 10*time.Second {
+		t.Errorf("Retry-After past MaxDelay: waited %v, want 10s", got)
+	}
+	date := time.Now().Add(5 * time.Second).UTC().Format(http.TimeFormat)
+	if got := transport.backoff(0, withRetryAfter(date)); got <= 3*time.Second || got > 5*time.Second {
+		t.Errorf("Retry-After as a date: waited %v, want about 5s", got)
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		ceiling := min(transport.Config.BaseDelay<<attempt, transport.Config.MaxDelay)
+		if got := transport.backoff(attempt, withRetryAfter("soon")); got < 0 || got >= ceiling {
+			t.Errorf("attempt %d: waited %v, want below %v", attempt, got, ceiling)
+		}
+	}
+}
+
+func TestTransportOpensCircuit(t *testing.T) {
+	config := testConfig
+	config.MaxRetries = 0
+	config.FailureThreshold = 2
+	client, url, calls := newTestClient(t, config, http.StatusInternalServerError)
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	_, err := client.Get(url)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err = %v, want ErrCircuitOpen", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("calls = %d, the open circuit reached the server", calls.Load())
+	}
+}
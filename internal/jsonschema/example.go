@@ -0,0 +1,73 @@
+package jsonschema
+
+import (
+	"strings"
+)
+
+// Example builds a value that satisfies simple schemas: the first enum or
+// const value, required properties, minimum lengths and bounds. anyOf and
+// oneOf use their first option.
+func Example(schema map[string]interface{}) interface{} {
+	if constant, ok := schema["const"]; ok {
+		return constant
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0]
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if list, ok := schema[key].([]interface{}); ok && len(list) > 0 {
+			if sub, ok := list[0].(map[string]interface{}); ok {
+				return Example(sub)
+			}
+		}
+	}
+
+	names := schemaTypes(schema)
+	kind := "object"
+	if len(names) > 0 {
+		kind = names[0]
+	}
+
+	switch kind {
+	case "object":
+		object := map[string]interface{}{}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, name := range sortedKeys(properties) {
+			if sub, ok := properties[name].(map[string]interface{}); ok {
+				object[name] = Example(sub)
+			}
+		}
+		return object
+	case "array":
+		array := []interface{}{}
+		items, _ := schema["items"].(map[string]interface{})
+		min, _ := number(schema["minItems"])
+		for i := 0; i < int(min); i++ {
+			array = append(array, Example(items))
+		}
+		return array
+	case "string":
+		text := "example"
+		if min, ok := number(schema["minLength"]); ok && len(text) < int(min) {
+			text += strings.Repeat("x", int(min)-len(text))
+		}
+		if max, ok := number(schema["maxLength"]); ok && len(text) > int(max) {
+			text = text[:int(max)]
+		}
+		return text
+	case "number", "integer":
+		if min, ok := number(schema["minimum"]); ok {
+			return min
+		}
+		if min, ok := number(schema["exclusiveMinimum"]); ok {
+			return min + 1
+		}
+		if max, ok := number(schema["maximum"]); ok && max < 0 {
+			return max
+		}
+		return float64(0)
+	case "boolean":
+		return false
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+// Package jsonschema validates decoded JSON values against the subset of JSON
+// Schema that structured LLM outputs use: types, properties, required,
+// additionalProperties, items, enum, const, the usual string, number and
+// array bounds, pattern, and allOf/anyOf/oneOf. $ref and formats are not
+// supported.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Error is a single validation failure. Path is a JSON Pointer to the
+// offending value, empty for the document itself.
+type Error struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+var types = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Check reports problems with the schema itself, so a bad schema is rejected
+// before anything is generated with it.
+func Check(schema map[string]interface{}) []Error {
+	var errs []Error
+	check(schema, "", &errs)
+	return errs
+}
+
+func check(schema map[string]interface{}, path string, errs *[]Error) {
+	add := func(format string, args ...interface{}) {
+		*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, name := range schemaTypes(schema) {
+		if !types[name] {
+			add("unknown type %q", name)
+		}
+	}
+	if raw, ok := schema["type"]; ok {
+		if _, isString := raw.(string); !isString {
+			if _, isList := raw.([]interface{}); !isList {
+				add("type must be a string or a list of strings")
+			}
+		}
+	}
+	if _, ok := schema["$ref"]; ok {
+		add("$ref is not supported")
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			add("invalid pattern: %v", err)
+		}
+	}
+	if required, ok := schema["required"]; ok {
+		if _, isList := required.([]interface{}); !isList {
+			add("required must be a list")
+		}
+	}
+
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		for _, name := range sortedKeys(properties) {
+			if sub, ok := properties[name].(map[string]interface{}); ok {
+				check(sub, path+"/properties/"+escape(name), errs)
+			} else {
+				*errs = append(*errs, Error{Path: path + "/properties/" + escape(name), Message: "must be a schema object"})
+			}
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties", "not"} {
+		if sub, ok := schema[key].(map[string]interface{}); ok {
+			check(sub, path+"/"+key, errs)
+		}
+	}
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		if list, ok := schema[key].([]interface{}); ok {
+			for i, item := range list {
+				if sub, ok := item.(map[string]interface{}); ok {
+					check(sub, fmt.Sprintf("%s/%s/%d", path, key, i), errs)
+				}
+			}
+		}
+	}
+}
+
+// Validate checks a value decoded with encoding/json against schema.
+func Validate(schema map[string]interface{}, value interface{}) []Error {
+	var errs []Error
+	validate(schema, value, "", &errs)
+	return errs
+}
+
+func validate(schema map[string]interface{}, value interface{}, path string, errs *[]Error) {
+	add := func(format string, args ...interface{}) {
+		*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if names := schemaTypes(schema); len(names) > 0 && !matchesType(names, value) {
+		add("expected %s, got %s", joinTypes(names), typeOf(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			if equal(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("must be one of %s", compact(enum))
+		}
+	}
+	if constant, ok := schema["const"]; ok && !equal(constant, value) {
+		add("must be %s", compact(constant))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		validateObject(schema, v, path, errs)
+	case []interface{}:
+		validateArray(schema, v, path, errs)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if min, ok := number(schema["minLength"]); ok && float64(length) < min {
+			add("must be at least %v characters", min)
+		}
+		if max, ok := number(schema["maxLength"]); ok && float64(length) > max {
+			add("must be at most %v characters", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				add("must match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if min, ok := number(schema["minimum"]); ok && v < min {
+			add("must be >= %v", min)
+		}
+		if max, ok := number(schema["maximum"]); ok && v > max {
+			add("must be <= %v", max)
+		}
+		if min, ok := number(schema["exclusiveMinimum"]); ok && v <= min {
+			add("must be > %v", min)
+		}
+		if max, ok := number(schema["exclusiveMaximum"]); ok && v >= max {
+			add("must be < %v", max)
+		}
+	}
+
+	validateCombinators(schema, value, path, errs)
+}
+
+func validateObject(schema map[string]interface{}, object map[string]interface{}, path string, errs *[]Error) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := object[name]; !present {
+					*errs = append(*errs, Error{Path: path + "/" + escape(name), Message: "is required"})
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	for _, name := range sortedKeys(object) {
+		child := path + "/" + escape(name)
+		if sub, ok := properties[name].(map[string]interface{}); ok {
+			validate(sub, object[name], child, errs)
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*errs = append(*errs, Error{Path: child, Message: "is not allowed"})
+			}
+		case map[string]interface{}:
+			validate(additional, object[name], child, errs)
+		}
+	}
+}
+
+func validateArray(schema map[string]interface{}, array []interface{}, path string, errs *[]Error) {
+	if min, ok := number(schema["minItems"]); ok && float64(len(array)) < min {
+		*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf("must have at least %v items", min)})
+	}
+	if max, ok := number(schema["maxItems"]); ok && float64(len(array)) > max {
+		*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf("must have at most %v items", max)})
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range array {
+			for j := i + 1; j < len(array); j++ {
+				if equal(array[i], array[j]) {
+					*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf("items %d and %d are equal", i, j)})
+				}
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range array {
+			validate(items, item, path+"/"+strconv.Itoa(i), errs)
+		}
+	}
+}
+
+func validateCombinators(schema map[string]interface{}, value interface{}, path string, errs *[]Error) {
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, item := range all {
+			if sub, ok := item.(map[string]interface{}); ok {
+				validate(sub, value, path, errs)
+			}
+		}
+	}
+
+	matches := func(list []interface{}) int {
+		count := 0
+		for _, item := range list {
+			if sub, ok := item.(map[string]interface{}); ok && len(Validate(sub, value)) == 0 {
+				count++
+			}
+		}
+		return count
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && matches(anyOf) == 0 {
+		*errs = append(*errs, Error{Path: path, Message: "must match at least one of the anyOf schemas"})
+	}
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		if n := matches(one); n != 1 {
+			*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf("must match exactly one of the oneOf schemas, matched %d", n)})
+		}
+	}
+	if not, ok := schema["not"].(map[string]interface{}); ok && len(Validate(not, value)) == 0 {
+		*errs = append(*errs, Error{Path: path, Message: "must not match the not schema"})
+	}
+}
+
+func schemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		names := make([]string, 0, len(t))
+		for _, name := range t {
+			if name, ok := name.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func matchesType(names []string, value interface{}) bool {
+	actual := typeOf(value)
+	for _, name := range names {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func joinTypes(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return fmt.Sprintf("one of %v", names)
+}
+
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func compact(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escape encodes a property name as a JSON Pointer segment.
+func escape(name string) string {
+	escaped := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '~':
+			escaped = append(escaped, "~0"...)
+		case '/':
+			escaped = append(escaped, "~1"...)
+		default:
+			escaped = append(escaped, name[i])
+		}
+	}
+	return string(escaped)
+}
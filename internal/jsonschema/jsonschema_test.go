@@ -0,0 +1,109 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, data string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+	return value
+}
+
+func TestValidate(t *testing.T) {
+	person := `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"role": {"enum": ["admin", "user"]},
+			"address": {
+				"type": "object",
+				"properties": {"city": {"type": "string"}},
+				"required": ["city"]
+			},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false
+	}`
+
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   []Error
+	}{
+		{"valid", person, `{"name": "Ada", "age": 36, "role": "admin", "address": {"city": "London"}, "tags": ["math"]}`, nil},
+		{"wrong type", person, `["Ada"]`, []Error{{"", "expected object, got array"}}},
+		{"missing required", person, `{"name": "Ada"}`, []Error{{"/age", "is required"}}},
+		{"nested type", person, `{"name": "Ada", "age": 36.5}`, []Error{{"/age", "expected integer, got number"}}},
+		{"enum", person, `{"name": "Ada", "age": 36, "role": "owner"}`, []Error{{"/role", `must be one of ["admin","user"]`}}},
+		{"nested required", person, `{"name": "Ada", "age": 36, "address": {}}`, []Error{{"/address/city", "is required"}}},
+		{"array items", person, `{"name": "Ada", "age": 36, "tags": ["math", 1]}`, []Error{{"/tags/1", "expected string, got integer"}}},
+		{"array bounds", person, `{"name": "Ada", "age": 36, "tags": ["a", "b", "c"]}`, []Error{{"/tags", "must have at most 2 items"}}},
+		{"additional property", person, `{"name": "Ada", "age": 36, "email": "ada@example.com"}`, []Error{{"/email", "is not allowed"}}},
+		{"several errors", person, `{"name": "", "age": -1}`, []Error{{"/age", "must be >= 0"}, {"/name", "must be at least 1 characters"}}},
+		{"additional schema", `{"type": "object", "additionalProperties": {"type": "number"}}`, `{"a": 1, "b": "2"}`, []Error{{"/b", "expected number, got string"}}},
+		{"integer is a number", `{"type": "number", "maximum": 10}`, `3`, nil},
+		{"nullable", `{"type": ["string", "null"]}`, `null`, nil},
+		{"type list", `{"type": ["string", "null"]}`, `1`, []Error{{"", "expected one of [string null], got integer"}}},
+		{"const", `{"const": "yes"}`, `"no"`, []Error{{"", `must be "yes"`}}},
+		{"pattern", `{"type": "string", "pattern": "^[a-z]+$"}`, `"Ada"`, []Error{{"", `must match pattern "^[a-z]+$"`}}},
+		{"unique items", `{"type": "array", "uniqueItems": true}`, `[1, 2, 1]`, []Error{{"", "items 0 and 2 are equal"}}},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"type": "boolean"}]}`, `1`, []Error{{"", "must match at least one of the anyOf schemas"}}},
+		{"oneOf", `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, []Error{{"", "must match exactly one of the oneOf schemas, matched 2"}}},
+		{"escaped path", `{"type": "object", "properties": {"a/b": {"type": "string"}}}`, `{"a/b": 1}`, []Error{{"/a~1b", "expected string, got integer"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := decode(t, tt.schema).(map[string]interface{})
+			got := Validate(schema, decode(t, tt.value))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate(%s) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   []Error
+	}{
+		{"valid", `{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}`, nil},
+		{"unknown type", `{"type": "text"}`, []Error{{"", `unknown type "text"`}}},
+		{"type not a string", `{"type": 1}`, []Error{{"", "type must be a string or a list of strings"}}},
+		{"ref", `{"$ref": "#/definitions/a"}`, []Error{{"", "$ref is not supported"}}},
+		{"pattern", `{"type": "string", "pattern": "("}`, []Error{{"", "invalid pattern: error parsing regexp: missing closing ): `(`"}}},
+		{"required not a list", `{"required": "name"}`, []Error{{"", "required must be a list"}}},
+		{"property not a schema", `{"properties": {"name": "string"}}`, []Error{{"/properties/name", "must be a schema object"}}},
+		{"nested", `{"items": {"properties": {"a": {"type": "int"}}}}`, []Error{{"/items/properties/a", `unknown type "int"`}}},
+		{"combinator", `{"anyOf": [{"type": "string"}, {"type": "str"}]}`, []Error{{"/anyOf/1", `unknown type "str"`}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Check(decode(t, tt.schema).(map[string]interface{}))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%s) = %v, want %v", tt.schema, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	if got := (Error{Path: "/age", Message: "is required"}).Error(); got != "/age: is required" {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := (Error{Message: "expected object, got array"}).Error(); got != "expected object, got array" {
+		t.Errorf("Error() without a path = %q", got)
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/prompts"
 	"gorm.io/gorm"
 )
 
@@ -11,6 +14,13 @@ type User struct {
 	PublicID     string `json:"public_id" gorm:"uniqueIndex"`
 	MessageCount int    `json:"message_count" gorm:"default:0"`
 	Chats        []Chat `json:"chats"`
+
+	// Plan sets the user's quotas; the limits below only apply to the
+	// "custom" plan, zero meaning unlimited.
+	Plan           string `json:"plan" gorm:"default:free"`
+	MessagesPerDay int    `json:"messages_per_day,omitempty" gorm:"default:0"`
+	TokensPerMonth int    `json:"tokens_per_month,omitempty" gorm:"default:0"`
+	MaxChats       int    `json:"max_chats,omitempty" gorm:"default:0"`
 }
 
 type Chat struct {
@@ -18,12 +28,127 @@ type Chat struct {
 	UserID   uint      `json:"user_id"`
 	Title    string    `json:"title"` // Optional: First message or summary
 	Messages []Message `json:"messages"`
+
+	// Generation settings, empty for the backend defaults.
+	Provider    string   `json:"provider" gorm:"default:null"`
+	ModelName   string   `json:"model" gorm:"column:model;default:null"`
+	Temperature *float64 `json:"temperature"`
+	TopP        *float64 `json:"top_p"`
+	MaxTokens   int      `json:"max_tokens" gorm:"default:0"`
+
+	PersonaID *uint `json:"persona_id" gorm:"index"` // Instructions sent with every turn
+
+	// Messages form a tree once replies are regenerated; ActiveLeafID is the
+	// last message of the branch the chat continues from.
+	ActiveLeafID *uint `json:"active_leaf_id"`
+}
+
+// Persona is a reusable assistant: the instructions sent with every turn of
+// the chats that use it and the settings those chats start with.
+type Persona struct {
+	gorm.Model
+	Name         string `json:"name" gorm:"index;not null"`
+	Description  string `json:"description"`
+	SystemPrompt string `json:"system_prompt" gorm:"not null"`
+	Greeting     string `json:"greeting" gorm:"default:null"`  // First assistant message of new chats
+	BuiltIn      bool   `json:"built_in" gorm:"default:false"` // Seeded on startup, read-only
+
+	// Default generation settings, empty for the backend defaults.
+	Provider    string   `json:"provider" gorm:"default:null"`
+	ModelName   string   `json:"model" gorm:"column:model;default:null"`
+	Temperature *float64 `json:"temperature"`
+	TopP        *float64 `json:"top_p"`
+	MaxTokens   int      `json:"max_tokens" gorm:"default:0"`
+}
+
+// PromptTemplate is one version of a named prompt. Saving a prompt adds a
+// version and the latest one is used.
+type PromptTemplate struct {
+	gorm.Model
+	Name        string             `json:"name" gorm:"uniqueIndex:idx_prompt_version;not null"`
+	Version     int                `json:"version" gorm:"uniqueIndex:idx_prompt_version;not null"`
+	Description string             `json:"description"`
+	Body        string             `json:"body" gorm:"not null"` // Go text/template
+	Variables   []prompts.Variable `json:"variables" gorm:"serializer:json"`
 }
 
 type Message struct {
 	gorm.Model
 	ChatID         uint   `json:"chat_id"`
-	Role           string `json:"role"` // "user" or "assistant"
+	ParentID       *uint  `json:"parent_id" gorm:"index"` // Message this one follows, nil for the first
+	Role           string `json:"role"`                   // "user", "assistant" or "tool"
 	Content        string `json:"content"`
 	ModelMessageId string `json:"model_message_id" gorm:"default:null"`
+	Incomplete     bool   `json:"incomplete" gorm:"default:false"` // Stream was cut off before the reply finished
+	Provider       string `json:"provider" gorm:"default:null"`    // LLM backend that produced the reply
+	Failovers      string `json:"failovers,omitempty" gorm:"default:null"`
+
+	// Model and parameters that produced an assistant reply; nil parameters
+	// were left to the backend default.
+	ModelName   string   `json:"model,omitempty" gorm:"column:model;default:null"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty" gorm:"default:0"`
+
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty" gorm:"serializer:json"` // Tools an assistant turn called
+	ToolCallID string     `json:"tool_call_id,omitempty" gorm:"default:null"`  // Call a tool turn answers
+	ToolName   string     `json:"tool_name,omitempty" gorm:"default:null"`
+
+	TokenEstimate *TokenEstimate `json:"token_estimate,omitempty" gorm:"-"` // Prompt size, only on fresh replies
+
+	Attachments []Attachment `json:"attachments,omitempty"` // Images sent with a user turn
+}
+
+// Attachment is a file uploaded with a message. The content lives in the
+// attachment storage under StorageKey.
+type Attachment struct {
+	gorm.Model
+	MessageID  uint   `json:"message_id" gorm:"index"`
+	FileName   string `json:"file_name"`
+	MIMEType   string `json:"mime_type"`
+	Size       int64  `json:"size"`
+	StorageKey string `json:"-"`
+}
+
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// UsageRecord is one LLM call billed to a user: its reported token counts
+// and their cost from the price table at the time.
+type UsageRecord struct {
+	ID               uint      `json:"id" gorm:"primarykey"`
+	CreatedAt        time.Time `json:"created_at" gorm:"index"`
+	UserID           uint      `json:"user_id" gorm:"index;not null"`
+	ChatID           uint      `json:"chat_id" gorm:"index"`
+	MessageID        *uint     `json:"message_id"` // Assistant message the call produced
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	Cost             float64   `json:"cost"` // USD
+}
+
+// TokenEstimate describes the prompt sent to the LLM, counted before sending.
+type TokenEstimate struct {
+	Model           string `json:"model"`
+	PromptTokens    int    `json:"prompt_tokens"`
+	ContextWindow   int    `json:"context_window"`
+	ReplyTokens     int    `json:"reply_tokens"`     // Kept free for the answer
+	DroppedMessages int    `json:"dropped_messages"` // Oldest turns left out to fit
+	Truncated       bool   `json:"truncated"`        // A turn was cut down to fit
+	Exact           bool   `json:"exact"`            // False when counts are estimated
+}
+
+// EmbeddingCache stores a computed embedding, keyed by the SHA-256 of the
+// text and the embedding model it was computed with.
+type EmbeddingCache struct {
+	Hash       string    `json:"hash" gorm:"primaryKey;size:64"`
+	Model      string    `json:"model" gorm:"primaryKey"`
+	Vector     []byte    `json:"-" gorm:"not null"` // Little-endian float32 values
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at" gorm:"index"`
 }
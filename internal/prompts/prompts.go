@@ -0,0 +1,269 @@
+// Package prompts renders prompt templates: Go text/template bodies whose
+// data is a set of declared, typed variables.
+package prompts
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Variable types.
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeList    = "list"
+)
+
+var types = map[string]bool{
+	TypeString: true, TypeNumber: true, TypeInteger: true, TypeBoolean: true, TypeList: true,
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,63}$`)
+
+// Variable declares a value a template can use, as {{.name}}. A variable
+// that isn't required and has no default renders as its type's zero value.
+type Variable struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Description string      `json:"description,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+}
+
+// Error is a problem with a template, its variables or the values rendered
+// with it. Variable is empty when the error is about the body.
+type Error struct {
+	Variable string `json:"variable,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (e Error) Error() string {
+	if e.Variable == "" {
+		return e.Message
+	}
+	return e.Variable + ": " + e.Message
+}
+
+// Errors is the list returned by Check and Render.
+type Errors []Error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+var funcs = template.FuncMap{
+	"join":  func(list []interface{}, sep string) string { return join(list, sep) },
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+func join(list []interface{}, sep string) string {
+	parts := make([]string, len(list))
+	for i, item := range list {
+		parts[i] = fmt.Sprint(item)
+	}
+	return strings.Join(parts, sep)
+}
+
+func parse(body string) (*template.Template, error) {
+	return template.New("prompt").Funcs(funcs).Option("missingkey=error").Parse(body)
+}
+
+// Check reports problems with a template before it's stored: a body that
+// doesn't parse, invalid variable declarations, and references to variables
+// that aren't declared, found by rendering with sample values.
+func Check(body string, variables []Variable) Errors {
+	var errs Errors
+	seen := map[string]bool{}
+	for _, variable := range variables {
+		add := func(format string, args ...interface{}) {
+			errs = append(errs, Error{Variable: variable.Name, Message: fmt.Sprintf(format, args...)})
+		}
+		switch {
+		case !validName.MatchString(variable.Name):
+			add("name must be letters, digits and underscores")
+		case seen[variable.Name]:
+			add("declared twice")
+		}
+		seen[variable.Name] = true
+		if !types[variable.Type] {
+			add("unknown type %q", variable.Type)
+			continue
+		}
+		if variable.Default != nil {
+			if _, err := convert(variable.Type, variable.Default); err != nil {
+				add("default %v", err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	tmpl, err := parse(body)
+	if err != nil {
+		return Errors{{Message: err.Error()}}
+	}
+	data := map[string]interface{}{}
+	for _, variable := range variables {
+		data[variable.Name] = sample(variable.Type)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, data); err != nil {
+		return Errors{{Message: err.Error()}}
+	}
+	return nil
+}
+
+// Render executes the template with values, after checking them against the
+// declared variables and filling in defaults.
+func Render(body string, variables []Variable, values map[string]interface{}) (string, error) {
+	data, errs := bind(variables, values)
+	if len(errs) > 0 {
+		return "", errs
+	}
+
+	tmpl, err := parse(body)
+	if err != nil {
+		return "", Errors{{Message: err.Error()}}
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", Errors{{Message: err.Error()}}
+	}
+	return out.String(), nil
+}
+
+// bind builds the template data from values: every declared variable gets
+// its value, its default or its zero value, and undeclared values are
+// rejected.
+func bind(variables []Variable, values map[string]interface{}) (map[string]interface{}, Errors) {
+	var errs Errors
+	data := make(map[string]interface{}, len(variables))
+	declared := make(map[string]bool, len(variables))
+	for _, variable := range variables {
+		declared[variable.Name] = true
+
+		value, ok := values[variable.Name]
+		if !ok || value == nil {
+			switch {
+			case variable.Default != nil:
+				value = variable.Default
+			case variable.Required:
+				errs = append(errs, Error{Variable: variable.Name, Message: "is required"})
+				continue
+			default:
+				data[variable.Name] = zero(variable.Type)
+				continue
+			}
+		}
+
+		converted, err := convert(variable.Type, value)
+		if err != nil {
+			errs = append(errs, Error{Variable: variable.Name, Message: err.Error()})
+			continue
+		}
+		data[variable.Name] = converted
+	}
+
+	var undeclared []string
+	for name := range values {
+		if !declared[name] {
+			undeclared = append(undeclared, name)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, name := range undeclared {
+		errs = append(errs, Error{Variable: name, Message: "is not declared"})
+	}
+	return data, errs
+}
+
+// convert checks a decoded JSON value against a variable type.
+func convert(kind string, value interface{}) (interface{}, error) {
+	switch kind {
+	case TypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case TypeNumber:
+		if n, ok := number(value); ok {
+			return n, nil
+		}
+	case TypeInteger:
+		if n, ok := number(value); ok && n == math.Trunc(n) {
+			return int64(n), nil
+		}
+	case TypeBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case TypeList:
+		switch list := value.(type) {
+		case []interface{}:
+			return list, nil
+		case []string:
+			items := make([]interface{}, len(list))
+			for i, item := range list {
+				items[i] = item
+			}
+			return items, nil
+		}
+	}
+	return nil, fmt.Errorf("must be of type %s", kind)
+}
+
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func zero(kind string) interface{} {
+	switch kind {
+	case TypeNumber:
+		return float64(0)
+	case TypeInteger:
+		return int64(0)
+	case TypeBoolean:
+		return false
+	case TypeList:
+		return []interface{}{}
+	}
+	return ""
+}
+
+// sample is a value of the type that exercises most of a template, used to
+// check it renders.
+func sample(kind string) interface{} {
+	switch kind {
+	case TypeNumber:
+		return float64(1)
+	case TypeInteger:
+		return int64(1)
+	case TypeBoolean:
+		return true
+	case TypeList:
+		return []interface{}{"sample"}
+	}
+	return "sample"
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/storage"
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm"
+)
+
+const (
+	MaxAttachments    = 4
+	MaxAttachmentSize = 5 << 20 // Bytes per file
+)
+
+// AttachmentTypes are the MIME types accepted for attachments, with the
+// extension they are stored under.
+var AttachmentTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+var (
+	ErrAttachmentsDisabled = errors.New("attachments are not enabled")
+	ErrTooManyAttachments  = fmt.Errorf("a message can't have more than %d attachments", MaxAttachments)
+	ErrAttachmentTooLarge  = fmt.Errorf("attachments can't be larger than %d MB", MaxAttachmentSize>>20)
+	ErrAttachmentType      = errors.New("attachments must be PNG, JPEG, GIF or WebP images")
+	ErrAttachmentNotFound  = errors.New("attachment not found")
+	ErrVisionUnsupported   = errors.New("the chat's model can't read images")
+)
+
+// Upload is a file sent along with a user message.
+type Upload struct {
+	FileName string
+	Data     []byte
+}
+
+// Attachments validates the files sent with messages, keeps them in Storage
+// and loads them back as images for the model.
+type Attachments struct {
+	Storage storage.Storage
+}
+
+func NewAttachments(store storage.Storage) *Attachments {
+	return &Attachments{Storage: store}
+}
+
+// Validate checks the uploads and returns their MIME types. The type is
+// sniffed from the content, whatever the client claims.
+func (a *Attachments) Validate(uploads []Upload) ([]string, error) {
+	if len(uploads) == 0 {
+		return nil, nil
+	}
+	if a == nil || a.Storage == nil {
+		return nil, ErrAttachmentsDisabled
+	}
+	if len(uploads) > MaxAttachments {
+		return nil, ErrTooManyAttachments
+	}
+
+	types := make([]string, len(uploads))
+	for i, upload := range uploads {
+		if len(upload.Data) > MaxAttachmentSize {
+			return nil, fmt.Errorf("%w: %s", ErrAttachmentTooLarge, upload.FileName)
+		}
+		types[i] = http.DetectContentType(upload.Data)
+		if _, ok := AttachmentTypes[types[i]]; !ok {
+			return nil, fmt.Errorf("%w: %s is %s", ErrAttachmentType, upload.FileName, types[i])
+		}
+	}
+	return types, nil
+}
+
+// save stores the uploads of a message created in tx. Files already stored
+// are removed again when one fails, the transaction rolls back the rows.
+func (a *Attachments) save(tx *gorm.DB, message *models.Message, uploads []Upload) error {
+	types, err := a.Validate(uploads)
+	if err != nil {
+		return err
+	}
+
+	var stored []string
+	for i, upload := range uploads {
+		key, err := attachmentKey(message.ChatID, types[i])
+		if err != nil {
+			return err
+		}
+		if err := a.Storage.Put(key, bytes.NewReader(upload.Data)); err != nil {
+			a.remove(stored)
+			return err
+		}
+		stored = append(stored, key)
+
+		message.Attachments = append(message.Attachments, models.Attachment{
+			MessageID:  message.ID,
+			FileName:   path.Base(upload.FileName),
+			MIMEType:   types[i],
+			Size:       int64(len(upload.Data)),
+			StorageKey: key,
+		})
+	}
+
+	if err := tx.Create(&message.Attachments).Error; err != nil {
+		a.remove(stored)
+		return err
+	}
+	return nil
+}
+
+func (a *Attachments) remove(keys []string) {
+	for _, key := range keys {
+		if err := a.Storage.Delete(key); err != nil {
+			log.Error("Failed to remove attachment ", key, ": ", err)
+		}
+	}
+}
+
+// attachmentKey is a random key in the chat's folder.
+func attachmentKey(chatID uint, mimeType string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("chats/%d/%s%s", chatID, hex.EncodeToString(id), AttachmentTypes[mimeType]), nil
+}
+
+// Open returns the content of an attachment. The caller closes it.
+func (a *Attachments) Open(attachment *models.Attachment) (io.ReadCloser, error) {
+	if a == nil || a.Storage == nil {
+		return nil, ErrAttachmentsDisabled
+	}
+	file, err := a.Storage.Open(attachment.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrAttachmentNotFound
+	}
+	return file, err
+}
+
+// images loads attachments as the images sent to the model.
+func (a *Attachments) images(attachments []models.Attachment) ([]llm.Image, error) {
+	var images []llm.Image
+	for i := range attachments {
+		file, err := a.Open(&attachments[i])
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, llm.Image{MIMEType: attachments[i].MIMEType, Data: data})
+	}
+	return images, nil
+}
+
+// GetAttachment returns an attachment by ID.
+func GetAttachment(id uint) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := database.DB.First(&attachment, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrNoUserMessage   = errors.New("message doesn't answer a user message")
+	ErrNotUserMessage  = errors.New("only user messages can be edited")
+)
+
+// GetMessage returns a message by ID.
+func GetMessage(id uint) (*models.Message, error) {
+	var message models.Message
+	err := database.DB.First(&message, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// chatMessages loads every message of the chat, with its attachments, by ID.
+// Chats are short enough to be walked in memory.
+func chatMessages(chatID uint) (map[uint]*models.Message, error) {
+	var messages []models.Message
+	if err := database.DB.Preload("Attachments").Where("chat_id = ?", chatID).Order("id").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*models.Message, len(messages))
+	for i := range messages {
+		byID[messages[i].ID] = &messages[i]
+	}
+	return byID, nil
+}
+
+// branchTo follows the parents of leafID up to the first message,
+// returning the branch oldest first.
+func branchTo(messages map[uint]*models.Message, leafID *uint) []models.Message {
+	var branch []models.Message
+	for id := leafID; id != nil; {
+		message, ok := messages[*id]
+		if !ok || len(branch) > len(messages) {
+			break
+		}
+		branch = append(branch, *message)
+		id = message.ParentID
+	}
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch
+}
+
+// branch returns the messages leading to leafID, leafID included, oldest
+// first.
+func branch(chatID uint, leafID *uint) ([]models.Message, error) {
+	if leafID == nil {
+		return []models.Message{}, nil
+	}
+	messages, err := chatMessages(chatID)
+	if err != nil {
+		return nil, err
+	}
+	return branchTo(messages, leafID), nil
+}
+
+// ActiveBranch returns the messages of the branch the chat continues from,
+// oldest first.
+func ActiveBranch(chat *models.Chat) ([]models.Message, error) {
+	return branch(chat.ID, chat.ActiveLeafID)
+}
+
+// SelectBranch makes the chat continue from the branch through messageID,
+// down to its most recent message, and returns that branch.
+func SelectBranch(chat *models.Chat, messageID uint) ([]models.Message, error) {
+	messages, err := chatMessages(chat.ID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := messages[messageID]; !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	// The newest child is the most recent answer or edit at every fork.
+	newest := map[uint]uint{}
+	for _, message := range messages {
+		if message.ParentID != nil && message.ID > newest[*message.ParentID] {
+			newest[*message.ParentID] = message.ID
+		}
+	}
+	leaf := messageID
+	for newest[leaf] != 0 {
+		leaf = newest[leaf]
+	}
+
+	if err := database.DB.Model(chat).Update("active_leaf_id", leaf).Error; err != nil {
+		return nil, err
+	}
+	chat.ActiveLeafID = &leaf
+	return branchTo(messages, &leaf), nil
+}
+
+// appendMessage creates message after the chat's active leaf in tx and
+// makes it the new leaf.
+func appendMessage(tx *gorm.DB, chat *models.Chat, message *models.Message) error {
+	message.ChatID = chat.ID
+	message.ParentID = chat.ActiveLeafID
+	if err := tx.Create(message).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(chat).Update("active_leaf_id", message.ID).Error; err != nil {
+		return err
+	}
+	leaf := message.ID
+	chat.ActiveLeafID = &leaf
+	return nil
+}
+
+// saveMessage appends message to the chat in its own transaction.
+func saveMessage(chat *models.Chat, message *models.Message) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		return appendMessage(tx, chat, message)
+	})
+}
+
+// Regenerate answers the user message that message answers again, as a new
+// branch next to the existing answer. Given a user message, it answers that
+// one. The chat switches to the new branch once the answer is saved.
+func (s *ChatService) Regenerate(ctx context.Context, chat *models.Chat, message *models.Message, onDelta func(delta string) error) (*models.Message, error) {
+	thread, err := branch(chat.ID, &message.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The answer may be a chain of tool calls and results: it starts after
+	// the last user message.
+	for i := len(thread) - 1; i >= 0; i-- {
+		if thread[i].Role != "user" {
+			continue
+		}
+		return s.Reply(ctx, chat, &thread[i], onDelta)
+	}
+	return nil, ErrNoUserMessage
+}
+
+// EditMessage forks the conversation at a user message: the new content is
+// saved as a sibling of message, with the same attachments, and becomes the
+// chat's active leaf. The original branch is kept as it was. Replying to the
+// edit replays the history up to the fork only.
+func (s *ChatService) EditMessage(chat *models.Chat, message *models.Message, content string) (*models.Message, error) {
+	if message.Role != "user" {
+		return nil, ErrNotUserMessage
+	}
+	if err := ValidateMessage(content); err != nil {
+		return nil, err
+	}
+
+	var attachments []models.Attachment
+	if err := database.DB.Where("message_id = ?", message.ID).Order("id").Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+
+	edited := models.Message{Role: "user", Content: content}
+	chat.ActiveLeafID = message.ParentID
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := appendMessage(tx, chat, &edited); err != nil {
+			return err
+		}
+		if len(attachments) == 0 {
+			return nil
+		}
+		// The files are shared with the original message, only the rows
+		// are copied.
+		for _, attachment := range attachments {
+			attachment.Model = gorm.Model{}
+			attachment.MessageID = edited.ID
+			edited.Attachments = append(edited.Attachments, attachment)
+		}
+		return tx.Create(&edited.Attachments).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	incrementMessageCount(chat.UserID)
+	return &edited, nil
+}
+
+// BackfillBranches links the messages of chats created before branching
+// in the order they were sent, so they continue from their last message.
+func BackfillBranches() error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`UPDATE messages SET parent_id = (
+				SELECT MAX(previous.id) FROM messages previous
+				WHERE previous.chat_id = messages.chat_id AND previous.id < messages.id)
+			WHERE parent_id IS NULL AND chat_id IN (SELECT id FROM chats WHERE active_leaf_id IS NULL)`).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE chats SET active_leaf_id = (
+				SELECT MAX(messages.id) FROM messages WHERE messages.chat_id = chats.id)
+			WHERE active_leaf_id IS NULL`).Error
+	})
+}
@@ -0,0 +1,422 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/tools"
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm"
+)
+
+const (
+	MaxMessageLength         = 300
+	DefaultMaxToolIterations = 5
+)
+
+var (
+	ErrMessageTooLong   = errors.New("Message exceeds 300 characters")
+	ErrTooManyToolCalls = errors.New("too many tool calls")
+)
+
+// InvalidSettingsError lists the chat settings the backend doesn't support.
+type InvalidSettingsError struct {
+	Errors []llm.SettingsError
+}
+
+func (e *InvalidSettingsError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Field + ": " + err.Message
+	}
+	return "invalid chat settings: " + strings.Join(messages, "; ")
+}
+
+// ChatService holds the chat flow shared by every transport (REST, SSE and
+// WebSocket), so all of them persist the same history.
+type ChatService struct {
+	LLM    llm.LLMProvider
+	Memory *Memory
+	// Timeout bounds every LLM call, on top of the caller's context.
+	Timeout time.Duration
+	// Tools the model may call; nil disables tool calling. The model gets
+	// MaxToolIterations rounds of calls before it has to answer.
+	Tools             *tools.Registry
+	MaxToolIterations int
+	// Catalog validates chat settings against the available models; nil
+	// accepts any.
+	Catalog *llm.Catalog
+	// Prices turns the tokens of every reply into the cost recorded in the
+	// usage ledger.
+	Prices PriceTable
+	// Attachments stores the images sent with user messages; nil refuses
+	// them.
+	Attachments *Attachments
+}
+
+func NewChatService(provider llm.LLMProvider, timeout time.Duration) *ChatService {
+	return &ChatService{
+		LLM:               provider,
+		Memory:            NewMemory(DefaultHistoryTokenBudget),
+		Timeout:           timeout,
+		MaxToolIterations: DefaultMaxToolIterations,
+		Prices:            DefaultPrices,
+	}
+}
+
+func ValidateMessage(content string) error {
+	if len(content) > MaxMessageLength {
+		return ErrMessageTooLong
+	}
+	return nil
+}
+
+// ValidateSettings checks settings against the model catalog, returning
+// them normalised or an *InvalidSettingsError.
+func (s *ChatService) ValidateSettings(settings llm.Settings) (llm.Settings, error) {
+	if s.Catalog == nil {
+		return settings, nil
+	}
+	settings, errs := s.Catalog.ValidateSettings(settings)
+	if len(errs) > 0 {
+		return settings, &InvalidSettingsError{Errors: errs}
+	}
+	return settings, nil
+}
+
+// StartChat creates a new chat for the user, titled after its first message.
+// With a persona, the settings left empty default to the persona's and its
+// greeting opens the chat.
+func (s *ChatService) StartChat(userID uint, firstMessage string, settings llm.Settings, persona *models.Persona) (*models.Chat, error) {
+	if persona != nil {
+		settings = withDefaults(settings, personaSettings(persona))
+	}
+	settings, err := s.ValidateSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	chat := models.Chat{
+		UserID: userID,
+		Title:  firstMessage, // Use first message as title for now
+	}
+	applySettings(&chat, settings)
+	if persona != nil {
+		chat.PersonaID = &persona.ID
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&chat).Error; err != nil {
+			return err
+		}
+		if persona == nil || persona.Greeting == "" {
+			return nil
+		}
+		return appendMessage(tx, &chat, &models.Message{Role: "assistant", Content: persona.Greeting})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+// UpdateSettings replaces the chat's generation settings.
+func (s *ChatService) UpdateSettings(chat *models.Chat, settings llm.Settings) error {
+	settings, err := s.ValidateSettings(settings)
+	if err != nil {
+		return err
+	}
+
+	applySettings(chat, settings)
+	return database.DB.Model(chat).
+		Select("Provider", "ModelName", "Temperature", "TopP", "MaxTokens").
+		Updates(chat).Error
+}
+
+// ChatSettings returns the generation settings stored on the chat.
+func ChatSettings(chat *models.Chat) llm.Settings {
+	return llm.Settings{
+		Provider:    chat.Provider,
+		Model:       chat.ModelName,
+		Temperature: chat.Temperature,
+		TopP:        chat.TopP,
+		MaxTokens:   chat.MaxTokens,
+	}
+}
+
+func applySettings(chat *models.Chat, settings llm.Settings) {
+	chat.Provider = settings.Provider
+	chat.ModelName = settings.Model
+	chat.Temperature = settings.Temperature
+	chat.TopP = settings.TopP
+	chat.MaxTokens = settings.MaxTokens
+}
+
+// model resolves the model a chat on provider is answered by.
+func (s *ChatService) model(provider string, model string) string {
+	if model == "" && s.Catalog != nil {
+		model = s.Catalog.DefaultModel(provider)
+	}
+	if model == "" {
+		model = s.LLM.DefaultModel()
+	}
+	return model
+}
+
+// CheckAttachments validates the uploads of a message and returns
+// ErrVisionUnsupported when the model of provider can't read them, so they
+// are refused before anything is saved.
+func (s *ChatService) CheckAttachments(provider string, model string, uploads []Upload) error {
+	if _, err := s.Attachments.Validate(uploads); err != nil {
+		return err
+	}
+	if len(uploads) > 0 && !s.Catalog.SupportsVision(provider, s.model(provider, model)) {
+		return ErrVisionUnsupported
+	}
+	return nil
+}
+
+// AddUserMessage persists a user message at the end of the chat's active
+// branch, with the uploads as its attachments, and counts it against the
+// chat owner.
+func (s *ChatService) AddUserMessage(chat *models.Chat, content string, uploads ...Upload) (*models.Message, error) {
+	if err := s.CheckAttachments(chat.Provider, chat.ModelName, uploads); err != nil {
+		return nil, err
+	}
+
+	userMsg := models.Message{
+		Role:    "user",
+		Content: content,
+	}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// The branch may have been switched since the chat was loaded.
+		var current models.Chat
+		if err := tx.Select("id", "active_leaf_id").First(&current, chat.ID).Error; err != nil {
+			return err
+		}
+		chat.ActiveLeafID = current.ActiveLeafID
+		if err := appendMessage(tx, chat, &userMsg); err != nil {
+			return err
+		}
+		if len(uploads) == 0 {
+			return nil
+		}
+		return s.Attachments.save(tx, &userMsg, uploads)
+	})
+	if err != nil {
+		return nil, err
+	}
+	incrementMessageCount(chat.UserID)
+	return &userMsg, nil
+}
+
+// Reply asks the LLM to answer the user message and persists the assistant
+// message after it, as the chat's new active leaf. The earlier turns of the
+// user message's branch are replayed through Memory for providers that keep
+// no conversation state, fitted to the model's context window; the returned
+// message carries the prompt's token estimate.
+// Tool calls are run and answered until the model replies with text; every
+// call and result is persisted as its own message.
+// When onDelta is not nil the answer is streamed through it. An interrupted
+// stream still persists the partial answer, flagged as incomplete, and returns
+// it together with the error. Cancelling ctx cancels the upstream call.
+func (s *ChatService) Reply(ctx context.Context, chat *models.Chat, userMsg *models.Message, onDelta func(delta string) error) (*models.Message, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	model := s.model(chat.Provider, chat.ModelName)
+
+	persona, err := chatPersona(chat)
+	if err != nil {
+		return nil, err
+	}
+	system := systemInstructions(persona)
+
+	thread, err := branch(chat.ID, &userMsg.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(thread) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	userMsg, history := &thread[len(thread)-1], thread[:len(thread)-1]
+	chat.ActiveLeafID = &userMsg.ID
+
+	messages, estimate, err := s.Memory.Build(history, userMsg, system, model, chat.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+	if estimate.DroppedMessages > 0 || estimate.Truncated {
+		log.Debug("Chat ", chat.ID, " history trimmed to ", estimate.PromptTokens, " tokens, dropped ", estimate.DroppedMessages, " messages")
+	}
+
+	previous := lastReply(history)
+	request := llm.Request{
+		Model:            chat.ModelName,
+		Provider:         chat.Provider,
+		System:           system,
+		Messages:         messages,
+		PreviousID:       previous.ModelMessageId,
+		PreviousProvider: previous.Provider,
+		Temperature:      chat.Temperature,
+		TopP:             chat.TopP,
+		MaxTokens:        chat.MaxTokens,
+	}
+	if s.Tools != nil {
+		request.Tools = s.Tools.Definitions()
+	}
+
+	for iteration := 0; ; iteration++ {
+		var response *llm.Response
+		if onDelta == nil {
+			response, err = s.LLM.GenerateResponse(ctx, request)
+		} else {
+			response, err = s.LLM.StreamResponse(ctx, request, onDelta)
+		}
+
+		if err != nil && (response == nil || response.Text == "") {
+			return nil, err
+		}
+		if err != nil || len(response.ToolCalls) == 0 || s.Tools == nil {
+			return s.saveReply(chat, request, response, estimate, err)
+		}
+
+		if iteration >= s.MaxToolIterations {
+			return nil, ErrTooManyToolCalls
+		}
+
+		turns, err := s.runTools(ctx, chat, request, response)
+		if err != nil {
+			return nil, err
+		}
+		request.Messages = append(request.Messages, turns...)
+		request.PreviousID = response.ID
+		request.PreviousProvider = response.Provider
+	}
+}
+
+// saveReply persists the assistant answer.
+func (s *ChatService) saveReply(chat *models.Chat, request llm.Request, response *llm.Response, estimate *models.TokenEstimate, err error) (*models.Message, error) {
+	// Save Assistant Message, even if the stream was cut off, so the
+	// history reflects what the user actually saw.
+	assistantMsg := assistantMessage(chat, request, response)
+	assistantMsg.Incomplete = err != nil
+	assistantMsg.TokenEstimate = estimate
+	if err == nil {
+		assistantMsg.ModelMessageId = response.ID
+	} else {
+		log.Error("Response interrupted: ", err)
+	}
+	if dbErr := saveMessage(chat, &assistantMsg); dbErr != nil {
+		return nil, dbErr
+	}
+	s.recordUsage(chat, &assistantMsg, response)
+
+	return &assistantMsg, err
+}
+
+// runTools persists the assistant's tool calls, runs them and persists their
+// results, returning both as conversation turns. A failing tool doesn't stop
+// the chat: the error is handed to the model as the result. Every call gets a
+// result, even when ctx is cancelled, so the history stays valid for the
+// provider.
+func (s *ChatService) runTools(ctx context.Context, chat *models.Chat, request llm.Request, response *llm.Response) ([]llm.Message, error) {
+	callMsg := assistantMessage(chat, request, response)
+	callMsg.ModelMessageId = response.ID
+	for _, call := range response.ToolCalls {
+		callMsg.ToolCalls = append(callMsg.ToolCalls, models.ToolCall{ID: call.ID, Name: call.Name, Arguments: call.Arguments})
+	}
+	if err := saveMessage(chat, &callMsg); err != nil {
+		return nil, err
+	}
+	s.recordUsage(chat, &callMsg, response)
+
+	turns := []llm.Message{{Role: "assistant", Content: response.Text, ToolCalls: response.ToolCalls}}
+	for _, call := range response.ToolCalls {
+		result, err := s.Tools.Execute(ctx, call)
+		if err != nil {
+			log.Warn("Tool ", call.Name, " failed: ", err)
+			result = "Error: " + err.Error()
+		}
+
+		toolMsg := models.Message{
+			Role:       "tool",
+			Content:    result,
+			ToolCallID: call.ID,
+			ToolName:   call.Name,
+		}
+		if err := saveMessage(chat, &toolMsg); err != nil {
+			return nil, err
+		}
+		turns = append(turns, llm.Message{Role: "tool", Content: result, ToolCallID: call.ID})
+	}
+
+	return turns, ctx.Err()
+}
+
+// assistantMessage records a reply along with the model and parameters that
+// produced it.
+func assistantMessage(chat *models.Chat, request llm.Request, response *llm.Response) models.Message {
+	model := response.Model
+	if model == "" {
+		model = request.Model
+	}
+	return models.Message{
+		Role:        "assistant",
+		Content:     response.Text,
+		Provider:    response.Provider,
+		Failovers:   strings.Join(response.Failovers, "; "),
+		ModelName:   model,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		MaxTokens:   request.MaxTokens,
+	}
+}
+
+// History returns the messages of the chat's active branch, optionally only
+// those created after the given message ID.
+func (s *ChatService) History(chatID uint, afterID uint) ([]models.Message, error) {
+	var chat models.Chat
+	if err := database.DB.First(&chat, chatID).Error; err != nil {
+		return nil, err
+	}
+	branch, err := ActiveBranch(&chat)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []models.Message{}
+	for _, message := range branch {
+		if message.ID > afterID {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// lastReply returns the last assistant reply of the branch. Its provider ID
+// is used to continue the conversation on the provider side, unless the
+// reply is incomplete: the provider never finished it, so the history is
+// replayed instead. Following the branch matters: a reply on another branch
+// would continue a conversation the user doesn't see.
+func lastReply(branch []models.Message) models.Message {
+	for i := len(branch) - 1; i >= 0; i-- {
+		if branch[i].Role != "assistant" {
+			continue
+		}
+		previousAssistantMessage := branch[i]
+		if previousAssistantMessage.Incomplete {
+			previousAssistantMessage.ModelMessageId = ""
+		}
+		return previousAssistantMessage
+	}
+	return models.Message{}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/database/dbtest"
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/tools"
+)
+
+// scriptedProvider answers with responses in turn, repeating the last one.
+type scriptedProvider struct {
+	llm.MockLLM
+	responses []llm.Response
+	calls     int
+}
+
+func (p *scriptedProvider) GenerateResponse(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	response := p.responses[min(p.calls, len(p.responses)-1)]
+	p.calls++
+	return &response, nil
+}
+
+func toolCall(name string) llm.Response {
+	return llm.Response{
+		ToolCalls:    []llm.ToolCall{{ID: "call_" + name, Name: name, Arguments: "{}"}},
+		FinishReason: llm.FinishToolCalls,
+	}
+}
+
+// lookupTool registers a lookup tool answering result, counting its runs.
+func lookupTool(t *testing.T, result string, err error) (*tools.Registry, *int) {
+	t.Helper()
+	runs := 0
+	registry := tools.NewRegistry()
+	registerErr := registry.Register(tools.Tool{
+		Name: "lookup",
+		Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			runs++
+			return result, err
+		},
+	})
+	if registerErr != nil {
+		t.Fatal(registerErr)
+	}
+	return registry, &runs
+}
+
+// ask saves content as a new chat's first message and answers it.
+func ask(t *testing.T, service *ChatService, content string) (*models.Chat, *models.Message, error) {
+	t.Helper()
+	chat := &models.Chat{UserID: 1}
+	if err := database.DB.Create(chat).Error; err != nil {
+		t.Fatal(err)
+	}
+	userMsg, err := service.AddUserMessage(chat, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := service.Reply(context.Background(), chat, userMsg, nil)
+	return chat, reply, err
+}
+
+func TestReplyRunsTools(t *testing.T) {
+	tests := []struct {
+		name       string
+		result     string
+		err        error
+		wantResult string
+	}{
+		{name: "result", result: "42", wantResult: "42"},
+		{name: "failing tool", err: errors.New("backend down"), wantResult: "Error: backend down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbtest.Use(t)
+			registry, runs := lookupTool(t, tt.result, tt.err)
+			service := NewChatService(&llm.MockLLM{}, 0)
+			service.Tools = registry
+
+			chat, reply, err := ask(t, service, "What does lookup say?")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *runs != 1 {
+				t.Errorf("lookup ran %d times, want 1", *runs)
+			}
+			if want := "The tool returned: " + tt.wantResult; reply.Content != want {
+				t.Errorf("reply = %q, want %q", reply.Content, want)
+			}
+
+			// User message, tool call, tool result and answer.
+			messages, err := service.History(chat.ID, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 4 {
+				t.Fatalf("saved %d messages, want 4", len(messages))
+			}
+			call, result := messages[1], messages[2]
+			if len(call.ToolCalls) != 1 || call.ToolCalls[0].Name != "lookup" {
+				t.Errorf("tool call message = %+v", call)
+			}
+			if result.Role != "tool" || result.ToolName != "lookup" || result.ToolCallID != call.ToolCalls[0].ID || result.Content != tt.wantResult {
+				t.Errorf("tool result message = %+v", result)
+			}
+		})
+	}
+}
+
+func TestReplyReportsUnknownTools(t *testing.T) {
+	dbtest.Use(t)
+	registry, runs := lookupTool(t, "42", nil)
+	provider := &scriptedProvider{responses: []llm.Response{toolCall("missing"), {Text: "Sorry."}}}
+	service := NewChatService(provider, 0)
+	service.Tools = registry
+
+	chat, reply, err := ask(t, service, "Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "Sorry." || *runs != 0 {
+		t.Errorf("reply = %q after %d lookups, want Sorry. and none", reply.Content, *runs)
+	}
+
+	messages, err := service.History(chat.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("saved %d messages, want 4", len(messages))
+	}
+	if result := messages[2]; result.Content != "Error: unknown tool: missing" {
+		t.Errorf("tool result = %q", result.Content)
+	}
+}
+
+func TestReplyStopsAfterMaxToolIterations(t *testing.T) {
+	dbtest.Use(t)
+	registry, runs := lookupTool(t, "42", nil)
+	provider := &scriptedProvider{responses: []llm.Response{toolCall("lookup")}}
+	service := NewChatService(provider, 0)
+	service.Tools = registry
+	service.MaxToolIterations = 3
+
+	_, reply, err := ask(t, service, "Hello")
+	if !errors.Is(err, ErrTooManyToolCalls) {
+		t.Fatalf("Reply() = %v, %v, want ErrTooManyToolCalls", reply, err)
+	}
+	// Every round runs the calls, then the model gets one more turn.
+	if *runs != 3 || provider.calls != 4 {
+		t.Errorf("lookup ran %d times over %d calls, want 3 over 4", *runs, provider.calls)
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm/clause"
+)
+
+const DefaultEmbeddingCacheSize = 10000
+
+// EmbeddingCacheStats counts where embeddings were served from.
+type EmbeddingCacheStats struct {
+	MemoryHits   int64 `json:"memory_hits"`
+	DatabaseHits int64 `json:"database_hits"`
+	Misses       int64 `json:"misses"`
+	MemoryItems  int   `json:"memory_items"`
+}
+
+// CachedEmbedder wraps a provider so embeddings are only computed once per
+// text and embedding model. Vectors are kept in Postgres, with an in-process
+// LRU in front; chat calls go straight to the provider. Cache failures are
+// logged and fall back to the provider, never failing the call.
+type CachedEmbedder struct {
+	llm.LLMProvider
+
+	mu      sync.Mutex
+	size    int
+	order   *list.List // Most recently used first
+	entries map[string]*list.Element
+
+	memoryHits   atomic.Int64
+	databaseHits atomic.Int64
+	misses       atomic.Int64
+}
+
+type cachedVector struct {
+	key    string
+	vector []float32
+}
+
+// NewCachedEmbedder wraps provider, keeping up to size vectors in memory. A
+// size of zero disables the memory layer.
+func NewCachedEmbedder(provider llm.LLMProvider, size int) *CachedEmbedder {
+	return &CachedEmbedder{
+		LLMProvider: provider,
+		size:        size,
+		order:       list.New(),
+		entries:     map[string]*list.Element{},
+	}
+}
+
+func (c *CachedEmbedder) Stats() EmbeddingCacheStats {
+	c.mu.Lock()
+	items := c.order.Len()
+	c.mu.Unlock()
+
+	return EmbeddingCacheStats{
+		MemoryHits:   c.memoryHits.Load(),
+		DatabaseHits: c.databaseHits.Load(),
+		Misses:       c.misses.Load(),
+		MemoryItems:  items,
+	}
+}
+
+func (c *CachedEmbedder) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	vectors, err := c.GenerateEmbeddings(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+func (c *CachedEmbedder) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	model := c.EmbeddingModelID()
+	if model == "" {
+		return c.LLMProvider.GenerateEmbeddings(ctx, texts)
+	}
+
+	vectors := make([][]float32, len(texts))
+
+	// Texts still missing, by hash; the same text may appear more than once.
+	missing := map[string][]int{}
+	for i, text := range texts {
+		hash := textHash(text)
+		if vector, ok := c.get(model, hash); ok {
+			c.memoryHits.Add(1)
+			vectors[i] = vector
+			continue
+		}
+		missing[hash] = append(missing[hash], i)
+	}
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	for hash, vector := range c.load(ctx, model, missing) {
+		c.databaseHits.Add(int64(len(missing[hash])))
+		c.put(model, hash, vector)
+		for _, i := range missing[hash] {
+			vectors[i] = vector
+		}
+		delete(missing, hash)
+	}
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	hashes := make([]string, 0, len(missing))
+	batch := make([]string, 0, len(missing))
+	for hash, indexes := range missing {
+		hashes = append(hashes, hash)
+		batch = append(batch, texts[indexes[0]])
+	}
+
+	computed, err := c.LLMProvider.GenerateEmbeddings(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+	if len(computed) != len(batch) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(computed), len(batch))
+	}
+
+	rows := make([]models.EmbeddingCache, len(hashes))
+	now := time.Now()
+	for j, hash := range hashes {
+		c.misses.Add(int64(len(missing[hash])))
+		c.put(model, hash, computed[j])
+		for _, i := range missing[hash] {
+			vectors[i] = computed[j]
+		}
+		rows[j] = models.EmbeddingCache{Hash: hash, Model: model, Vector: encodeVector(computed[j]), CreatedAt: now, LastUsedAt: now}
+	}
+
+	err = database.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+	if err != nil {
+		log.Warn("Failed to store embeddings: ", err)
+	}
+
+	return vectors, nil
+}
+
+// load reads the cached vectors for the given hashes and marks them as used.
+func (c *CachedEmbedder) load(ctx context.Context, model string, missing map[string][]int) map[string][]float32 {
+	hashes := make([]string, 0, len(missing))
+	for hash := range missing {
+		hashes = append(hashes, hash)
+	}
+
+	var rows []models.EmbeddingCache
+	db := database.DB.WithContext(ctx)
+	if err := db.Where("model = ? AND hash IN ?", model, hashes).Find(&rows).Error; err != nil {
+		log.Warn("Failed to read embedding cache: ", err)
+		return nil
+	}
+
+	found := make(map[string][]float32, len(rows))
+	used := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if vector, ok := decodeVector(row.Vector); ok {
+			found[row.Hash] = vector
+			used = append(used, row.Hash)
+		}
+	}
+
+	if len(used) > 0 {
+		err := db.Model(&models.EmbeddingCache{}).Where("model = ? AND hash IN ?", model, used).Update("last_used_at", time.Now()).Error
+		if err != nil {
+			log.Warn("Failed to touch embedding cache: ", err)
+		}
+	}
+	return found
+}
+
+func (c *CachedEmbedder) get(model, hash string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[model+"/"+hash]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedVector).vector, true
+}
+
+func (c *CachedEmbedder) put(model, hash string, vector []float32) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := model + "/" + hash
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedVector{key: key, vector: vector})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedVector).key)
+	}
+}
+
+// PruneEmbeddingCache deletes cached embeddings not used since before, only
+// for model unless it's empty, and returns how many were removed.
+func PruneEmbeddingCache(before time.Time, model string) (int64, error) {
+	query := database.DB.Where("last_used_at < ?", before)
+	if model != "" {
+		query = query.Where("model = ?", model)
+	}
+	result := query.Delete(&models.EmbeddingCache{})
+	return result.RowsAffected, result.Error
+}
+
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, x := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(x))
+	}
+	return data
+}
+
+func decodeVector(data []byte) ([]float32, bool) {
+	if len(data) == 0 || len(data)%4 != 0 {
+		return nil, false
+	}
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector, true
+}
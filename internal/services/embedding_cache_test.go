@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// countingEmbedder counts the texts the provider is asked to embed.
+type countingEmbedder struct {
+	llm.MockLLM
+	texts []string
+}
+
+func (p *countingEmbedder) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	p.texts = append(p.texts, texts...)
+	return p.MockLLM.GenerateEmbeddings(ctx, texts)
+}
+
+// useEmptyDB points database.DB at a database that never returns rows, so
+// only the memory layer of the cache can hit.
+func useEmptyDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+}
+
+func TestCachedEmbedderLRU(t *testing.T) {
+	useEmptyDB(t)
+	provider := &countingEmbedder{MockLLM: llm.MockLLM{EmbeddingDimensions: 8}}
+	cache := NewCachedEmbedder(provider, 2)
+	ctx := context.Background()
+
+	embed := func(texts ...string) {
+		t.Helper()
+		vectors, err := cache.GenerateEmbeddings(ctx, texts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vectors) != len(texts) {
+			t.Fatalf("got %d vectors for %d texts", len(vectors), len(texts))
+		}
+	}
+
+	// A text sent twice in one call is embedded once, but both count.
+	embed("a", "b", "a")
+	if len(provider.texts) != 2 {
+		t.Errorf("provider embedded %q, want each text once", provider.texts)
+	}
+	if stats := cache.Stats(); stats.Misses != 3 || stats.MemoryHits != 0 || stats.MemoryItems != 2 {
+		t.Errorf("stats = %+v, want 3 misses and 2 items", stats)
+	}
+
+	// Using a makes b the least recently used, so c evicts b.
+	embed("a")
+	embed("c")
+	provider.texts = nil
+	embed("a", "c")
+	if len(provider.texts) != 0 {
+		t.Errorf("provider embedded %q, want a and c served from memory", provider.texts)
+	}
+	embed("b")
+	if len(provider.texts) != 1 || provider.texts[0] != "b" {
+		t.Errorf("provider embedded %q, want the evicted b again", provider.texts)
+	}
+
+	if stats := cache.Stats(); stats.MemoryHits != 3 || stats.Misses != 5 || stats.DatabaseHits != 0 || stats.MemoryItems != 2 {
+		t.Errorf("stats = %+v, want 3 memory hits, 5 misses and 2 items", stats)
+	}
+}
+
+func TestCachedEmbedderWithoutMemory(t *testing.T) {
+	useEmptyDB(t)
+	provider := &countingEmbedder{MockLLM: llm.MockLLM{EmbeddingDimensions: 8}}
+	cache := NewCachedEmbedder(provider, 0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GenerateEmbedding(context.Background(), "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := cache.Stats(); len(provider.texts) != 2 || stats.Misses != 2 || stats.MemoryItems != 0 {
+		t.Errorf("stats = %+v after %d embeddings, want every call to miss", stats, len(provider.texts))
+	}
+}
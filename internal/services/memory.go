@@ -0,0 +1,185 @@
+package services
+
+import (
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/tokenizer"
+)
+
+const (
+	DefaultHistoryTokenBudget = 4096
+	DefaultReplyTokens        = 1024
+)
+
+// A turn cut down to fewer tokens than this carries too little context to be
+// worth sending, so it's dropped instead.
+const minTruncatedTokens = 32
+
+// imageTokens is what an attached image is counted as. Providers bill images
+// by size and detail; this is a large image at OpenAI's high detail.
+const imageTokens = 765
+
+// Memory assembles the conversation replayed to the provider from the chat's
+// stored messages. Providers that keep the conversation on their side only
+// use the latest turns, but the full history is still built so a failover
+// backend can pick the conversation up.
+type Memory struct {
+	// TokenBudget caps the size of the replayed history, on top of the
+	// model's context window. The oldest turns are dropped first; the
+	// current user message is always kept. Zero means the context window is
+	// the only limit.
+	TokenBudget int
+	// ReplyTokens is kept free in the context window for the answer.
+	ReplyTokens int
+	// Attachments loads the images of the replayed turns; nil sends the
+	// text only.
+	Attachments *Attachments
+}
+
+func NewMemory(tokenBudget int) *Memory {
+	return &Memory{TokenBudget: tokenBudget, ReplyTokens: DefaultReplyTokens}
+}
+
+// limit is the number of prompt tokens that fit for the model, along with
+// the tokens kept for the reply: reply when set, ReplyTokens otherwise.
+func (m *Memory) limit(info tokenizer.ModelInfo, reply int) (int, int) {
+	if reply <= 0 {
+		reply = m.ReplyTokens
+	}
+	if reply > info.ContextWindow/2 {
+		reply = info.ContextWindow / 2
+	}
+
+	limit := info.ContextWindow - reply - tokenizer.ReplyOverhead
+	if m.TokenBudget > 0 && m.TokenBudget < limit {
+		limit = m.TokenBudget
+	}
+	return limit, reply
+}
+
+// Build returns the turns to send for userMsg, oldest first, ending with
+// userMsg itself, sized for model with its tokenizer and leaving replyTokens
+// for the answer. branch holds the messages before userMsg on its branch,
+// oldest first, with their attachments. The system prompt sent alongside is
+// counted first. The oldest turns that don't fit are dropped and the newest
+// of them may be cut down to its end.
+func (m *Memory) Build(branch []models.Message, userMsg *models.Message, system, model string, replyTokens int) ([]llm.Message, *models.TokenEstimate, error) {
+	// The history is walked newest first.
+	history := make([]models.Message, len(branch))
+	for i, message := range branch {
+		history[len(branch)-1-i] = message
+	}
+	images, err := m.images(userMsg.Attachments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := tokenizer.LookupModel(model)
+	tk := tokenizer.ForModel(model)
+	limit, reply := m.limit(info, replyTokens)
+	estimate := &models.TokenEstimate{
+		Model:         model,
+		ContextWindow: info.ContextWindow,
+		ReplyTokens:   reply,
+		Exact:         tokenizer.Exact(tk),
+	}
+
+	// The system prompt is never cut, only the conversation around it.
+	var systemTokens int
+	if system != "" {
+		systemTokens = tk.Count(system) + tokenizer.MessageOverhead
+	}
+
+	// Images of the user message are sent whole, only its text is cut.
+	content := userMsg.Content
+	imageCost := len(images) * imageTokens
+	used := systemTokens + imageCost + tk.Count(content) + tokenizer.MessageOverhead
+	if used > limit {
+		content = tk.KeepLast(content, max(limit-systemTokens-imageCost-tokenizer.MessageOverhead, 0))
+		used = systemTokens + imageCost + tk.Count(content) + tokenizer.MessageOverhead
+		estimate.Truncated = true
+	}
+
+	// Newest first while counting, reversed below.
+	kept := []llm.Message{}
+	costs := []int{}
+	for i, message := range history {
+		if empty(message) {
+			continue
+		}
+
+		turn := toTurn(message)
+		if turn.Images, err = m.images(message.Attachments); err != nil {
+			return nil, nil, err
+		}
+		cost := countTurn(tk, turn)
+		if used+cost <= limit {
+			kept = append(kept, turn)
+			costs = append(costs, cost)
+			used += cost
+			continue
+		}
+
+		// Tool calls can't be cut without breaking their arguments, nor
+		// images at all.
+		if room := limit - used - tokenizer.MessageOverhead; room >= minTruncatedTokens && len(turn.ToolCalls) == 0 && len(turn.Images) == 0 {
+			turn.Content = tk.KeepLast(turn.Content, room)
+			cost = countTurn(tk, turn)
+			kept = append(kept, turn)
+			costs = append(costs, cost)
+			used += cost
+			estimate.Truncated = true
+			i++
+		}
+		for _, dropped := range history[i:] {
+			if !empty(dropped) {
+				estimate.DroppedMessages++
+			}
+		}
+		break
+	}
+
+	// A tool result whose call was dropped can't be sent on its own.
+	for len(kept) > 0 && kept[len(kept)-1].Role == "tool" {
+		used -= costs[len(kept)-1]
+		kept = kept[:len(kept)-1]
+		estimate.DroppedMessages++
+	}
+
+	messages := make([]llm.Message, 0, len(kept)+1)
+	for i := len(kept) - 1; i >= 0; i-- {
+		messages = append(messages, kept[i])
+	}
+	messages = append(messages, llm.Message{Role: "user", Content: content, Images: images})
+
+	estimate.PromptTokens = used + tokenizer.ReplyOverhead
+	return messages, estimate, nil
+}
+
+// images loads the images of a turn, none without Attachments.
+func (m *Memory) images(attachments []models.Attachment) ([]llm.Image, error) {
+	if m.Attachments == nil {
+		return nil, nil
+	}
+	return m.Attachments.images(attachments)
+}
+
+func empty(message models.Message) bool {
+	return message.Content == "" && len(message.ToolCalls) == 0 && len(message.Attachments) == 0 && message.Role != "tool"
+}
+
+func toTurn(message models.Message) llm.Message {
+	turn := llm.Message{Role: message.Role, Content: message.Content, ToolCallID: message.ToolCallID}
+	for _, call := range message.ToolCalls {
+		turn.ToolCalls = append(turn.ToolCalls, llm.ToolCall{ID: call.ID, Name: call.Name, Arguments: call.Arguments})
+	}
+	return turn
+}
+
+func countTurn(tk tokenizer.Tokenizer, turn llm.Message) int {
+	tokens := tk.Count(turn.Content) + len(turn.Images)*imageTokens + tokenizer.MessageOverhead
+	for _, call := range turn.ToolCalls {
+		tokens += tk.Count(call.Name) + tk.Count(call.Arguments)
+	}
+	return tokens
+}
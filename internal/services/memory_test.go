@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/storage"
+	"github.com/LDTorres/golang-chat-ai/internal/tokenizer"
+)
+
+// testModel is unknown to the tokenizer, so counts are estimated: four
+// ASCII characters per token.
+const testModel = "test-model"
+
+// tokens returns text the estimator counts as n tokens, ending with mark.
+func tokens(n int, mark string) string {
+	return strings.Repeat("a", 4*n-len(mark)) + mark
+}
+
+func message(role string, content string) models.Message {
+	return models.Message{Role: role, Content: content}
+}
+
+func TestMemoryBuild(t *testing.T) {
+	overhead := tokenizer.MessageOverhead
+	call := models.Message{Role: "assistant", ToolCalls: []models.ToolCall{{ID: "1", Name: "lookup", Arguments: tokens(100, "}")}}}
+	result := models.Message{Role: "tool", Content: tokens(10, "result"), ToolCallID: "1"}
+
+	tests := []struct {
+		name      string
+		budget    int
+		system    string
+		history   []models.Message
+		user      string
+		want      []string // Contents sent, oldest first
+		dropped   int
+		truncated bool
+		prompt    int // Prompt tokens, reply overhead included
+	}{
+		{
+			name:    "everything fits",
+			budget:  100,
+			history: []models.Message{message("user", tokens(10, "u1")), message("assistant", tokens(10, "a1"))},
+			user:    tokens(10, "u2"),
+			want:    []string{tokens(10, "u1"), tokens(10, "a1"), tokens(10, "u2")},
+			prompt:  3*(10+overhead) + tokenizer.ReplyOverhead,
+		},
+		{
+			name:   "oldest turns dropped first",
+			budget: 40,
+			history: []models.Message{
+				message("user", tokens(10, "u1")), message("assistant", tokens(10, "a1")),
+				message("user", tokens(10, "u2")), message("assistant", tokens(10, "a2")),
+			},
+			user:    tokens(10, "u3"),
+			want:    []string{tokens(10, "u2"), tokens(10, "a2"), tokens(10, "u3")},
+			dropped: 2,
+			prompt:  3*(10+overhead) + tokenizer.ReplyOverhead,
+		},
+		{
+			name:      "oldest kept turn cut down",
+			budget:    100,
+			history:   []models.Message{message("user", tokens(100, "u1")), message("assistant", tokens(10, "a1"))},
+			user:      tokens(10, "u2"),
+			want:      []string{tokens(71, "u1"), tokens(10, "a1"), tokens(10, "u2")},
+			truncated: true,
+			prompt:    100 + tokenizer.ReplyOverhead,
+		},
+		{
+			name:    "too little room to cut",
+			budget:  50,
+			history: []models.Message{message("user", tokens(30, "u1")), message("assistant", tokens(10, "a1"))},
+			user:    tokens(10, "u2"),
+			want:    []string{tokens(10, "a1"), tokens(10, "u2")},
+			dropped: 1,
+			prompt:  2*(10+overhead) + tokenizer.ReplyOverhead,
+		},
+		{
+			name:    "orphan tool result dropped",
+			budget:  60,
+			history: []models.Message{message("user", tokens(10, "u1")), call, result, message("assistant", tokens(10, "a1"))},
+			user:    tokens(10, "u2"),
+			want:    []string{tokens(10, "a1"), tokens(10, "u2")},
+			dropped: 3,
+			prompt:  2*(10+overhead) + tokenizer.ReplyOverhead,
+		},
+		{
+			name:    "tool call kept with its result",
+			budget:  200,
+			history: []models.Message{call, result},
+			user:    tokens(10, "u2"),
+			want:    []string{"", tokens(10, "result"), tokens(10, "u2")},
+			prompt:  (100 + 2 + overhead) + 2*(10+overhead) + tokenizer.ReplyOverhead,
+		},
+		{
+			name:      "user message cut to the budget",
+			budget:    20,
+			history:   []models.Message{message("assistant", tokens(10, "a1"))},
+			user:      tokens(30, "u2"),
+			want:      []string{tokens(17, "u2")},
+			dropped:   1,
+			truncated: true,
+			prompt:    20 + tokenizer.ReplyOverhead,
+		},
+		{
+			name:    "system prompt counted first",
+			budget:  40,
+			system:  tokens(10, "system"),
+			history: []models.Message{message("user", tokens(10, "u1")), message("assistant", tokens(10, "a1"))},
+			user:    tokens(10, "u2"),
+			want:    []string{tokens(10, "a1"), tokens(10, "u2")},
+			dropped: 1,
+			prompt:  3*(10+overhead) + tokenizer.ReplyOverhead,
+		},
+		{
+			name:    "empty turns skipped",
+			budget:  100,
+			history: []models.Message{message("user", tokens(10, "u1")), message("assistant", "")},
+			user:    tokens(10, "u2"),
+			want:    []string{tokens(10, "u1"), tokens(10, "u2")},
+			prompt:  2*(10+overhead) + tokenizer.ReplyOverhead,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := NewMemory(tt.budget)
+			user := message("user", tt.user)
+			messages, estimate, err := memory.Build(tt.history, &user, tt.system, testModel, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, len(messages))
+			for i, message := range messages {
+				got[i] = message.Content
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("sent %q, want %q", got, tt.want)
+			}
+			if estimate.DroppedMessages != tt.dropped || estimate.Truncated != tt.truncated {
+				t.Errorf("dropped %d, truncated %v, want %d, %v", estimate.DroppedMessages, estimate.Truncated, tt.dropped, tt.truncated)
+			}
+			if estimate.PromptTokens != tt.prompt {
+				t.Errorf("prompt tokens = %d, want %d", estimate.PromptTokens, tt.prompt)
+			}
+			if estimate.Exact {
+				t.Error("estimated counts reported as exact")
+			}
+		})
+	}
+}
+
+func TestMemoryBuildImages(t *testing.T) {
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("chats/1/image.png", bytes.NewReader([]byte("png"))); err != nil {
+		t.Fatal(err)
+	}
+	image := models.Attachment{MIMEType: "image/png", StorageKey: "chats/1/image.png"}
+
+	memory := NewMemory(800)
+	memory.Attachments = NewAttachments(store)
+
+	// The image of the user message costs imageTokens, whatever its size,
+	// leaving room for one more turn.
+	user := models.Message{Role: "user", Content: tokens(10, "u2"), Attachments: []models.Attachment{image}}
+	history := []models.Message{
+		{Role: "user", Content: tokens(10, "u1"), Attachments: []models.Attachment{image}},
+		message("assistant", tokens(10, "a1")),
+	}
+	messages, estimate, err := memory.Build(history, &user, "", testModel, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 || messages[0].Content != tokens(10, "a1") {
+		t.Fatalf("sent %+v, want the answer and the user message", messages)
+	}
+	if images := messages[1].Images; len(images) != 1 || string(images[0].Data) != "png" {
+		t.Errorf("user message images = %+v", images)
+	}
+	if want := imageTokens + 2*(10+tokenizer.MessageOverhead) + tokenizer.ReplyOverhead; estimate.PromptTokens != want {
+		t.Errorf("prompt tokens = %d, want %d", estimate.PromptTokens, want)
+	}
+	// An older turn with an image can't be cut down, it's dropped whole.
+	if estimate.DroppedMessages != 1 || estimate.Truncated {
+		t.Errorf("dropped %d, truncated %v, want the older turn dropped", estimate.DroppedMessages, estimate.Truncated)
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPersonaNotFound = errors.New("persona not found")
+	ErrBuiltinPersona  = errors.New("built-in personas can't be changed")
+	ErrInvalidPersona  = errors.New("invalid persona")
+)
+
+// BuiltinPersonas are seeded on startup by SeedPersonas.
+var BuiltinPersonas = []models.Persona{
+	{
+		Name:        "Assistant",
+		Description: "A concise general purpose assistant.",
+		SystemPrompt: "You are a helpful assistant. Answer clearly and concisely, " +
+			"ask for clarification when a request is ambiguous and say so when you don't know something.",
+	},
+	{
+		Name:        "Code Reviewer",
+		Description: "Reviews code for bugs, readability and idiomatic style.",
+		SystemPrompt: "You are an experienced software engineer reviewing code. Point out bugs first, " +
+			"then readability and style issues, and suggest concrete fixes with short code snippets. " +
+			"Don't rewrite code that is already fine.",
+		Greeting:    "Paste the code you want reviewed and tell me what it should do.",
+		Temperature: floatPtr(0.2),
+	},
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// SeedPersonas creates the built-in personas, or updates them to their
+// current definition.
+func SeedPersonas() error {
+	for _, builtin := range BuiltinPersonas {
+		builtin.BuiltIn = true
+		var persona models.Persona
+		err := database.DB.
+			Where(models.Persona{Name: builtin.Name, BuiltIn: true}).
+			Assign(builtin).
+			FirstOrCreate(&persona).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Personas lists the personas, built-in ones first.
+func Personas() ([]models.Persona, error) {
+	var personas []models.Persona
+	err := database.DB.Order("built_in desc, name").Find(&personas).Error
+	return personas, err
+}
+
+// GetPersona returns the persona with the id, or ErrPersonaNotFound.
+func GetPersona(id uint) (*models.Persona, error) {
+	var persona models.Persona
+	if err := database.DB.First(&persona, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPersonaNotFound
+		}
+		return nil, err
+	}
+	return &persona, nil
+}
+
+// validatePersona checks the persona's fields and its default settings.
+func (s *ChatService) validatePersona(persona *models.Persona) error {
+	persona.Name = strings.TrimSpace(persona.Name)
+	if persona.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidPersona)
+	}
+	if strings.TrimSpace(persona.SystemPrompt) == "" {
+		return fmt.Errorf("%w: system_prompt is required", ErrInvalidPersona)
+	}
+
+	settings, err := s.ValidateSettings(personaSettings(persona))
+	if err != nil {
+		return err
+	}
+	persona.Provider = settings.Provider
+	return nil
+}
+
+// CreatePersona validates and stores a new persona.
+func (s *ChatService) CreatePersona(persona *models.Persona) error {
+	persona.ID = 0
+	persona.BuiltIn = false
+	if err := s.validatePersona(persona); err != nil {
+		return err
+	}
+	return database.DB.Create(persona).Error
+}
+
+// UpdatePersona replaces the fields of a persona. Chats using it get the new
+// instructions on their next turn; their settings are left as they are.
+func (s *ChatService) UpdatePersona(id uint, update models.Persona) (*models.Persona, error) {
+	persona, err := GetPersona(id)
+	if err != nil {
+		return nil, err
+	}
+	if persona.BuiltIn {
+		return nil, ErrBuiltinPersona
+	}
+
+	update.Model = persona.Model
+	update.BuiltIn = false
+	if err := s.validatePersona(&update); err != nil {
+		return nil, err
+	}
+	if err := database.DB.Save(&update).Error; err != nil {
+		return nil, err
+	}
+	return &update, nil
+}
+
+// DeletePersona removes a persona and detaches it from the chats using it.
+func DeletePersona(id uint) error {
+	persona, err := GetPersona(id)
+	if err != nil {
+		return err
+	}
+	if persona.BuiltIn {
+		return ErrBuiltinPersona
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Chat{}).Where("persona_id = ?", id).Update("persona_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(persona).Error
+	})
+}
+
+// SetPersona switches the persona a chat uses; nil removes it.
+func SetPersona(chat *models.Chat, persona *models.Persona) error {
+	chat.PersonaID = nil
+	if persona != nil {
+		chat.PersonaID = &persona.ID
+	}
+	return database.DB.Model(chat).Update("persona_id", chat.PersonaID).Error
+}
+
+// chatPersona returns the persona of the chat, nil when it has none.
+func chatPersona(chat *models.Chat) (*models.Persona, error) {
+	if chat.PersonaID == nil {
+		return nil, nil
+	}
+	persona, err := GetPersona(*chat.PersonaID)
+	if errors.Is(err, ErrPersonaNotFound) {
+		return nil, nil
+	}
+	return persona, err
+}
+
+func personaSettings(persona *models.Persona) llm.Settings {
+	return llm.Settings{
+		Provider:    persona.Provider,
+		Model:       persona.ModelName,
+		Temperature: persona.Temperature,
+		TopP:        persona.TopP,
+		MaxTokens:   persona.MaxTokens,
+	}
+}
+
+// withDefaults fills the settings left empty from defaults. The provider and
+// model are taken together so a chosen model never gets a foreign provider.
+func withDefaults(settings, defaults llm.Settings) llm.Settings {
+	if settings.Provider == "" && settings.Model == "" {
+		settings.Provider = defaults.Provider
+		settings.Model = defaults.Model
+	}
+	if settings.Temperature == nil {
+		settings.Temperature = defaults.Temperature
+	}
+	if settings.TopP == nil {
+		settings.TopP = defaults.TopP
+	}
+	if settings.MaxTokens == 0 {
+		settings.MaxTokens = defaults.MaxTokens
+	}
+	return settings
+}
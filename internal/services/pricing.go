@@ -0,0 +1,92 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// Price is what a model charges, in USD per million tokens.
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// PriceTable maps model name prefixes to prices; the longest matching prefix
+// wins, so "gpt-4o-mini" isn't billed as "gpt-4o". Models without a price,
+// like local ones, cost nothing.
+type PriceTable map[string]Price
+
+// DefaultPrices are the list prices of the hosted models, as published by
+// their vendors.
+var DefaultPrices = PriceTable{
+	"gpt-5":        {Input: 1.25, Output: 10},
+	"gpt-5-mini":   {Input: 0.25, Output: 2},
+	"gpt-5-nano":   {Input: 0.05, Output: 0.4},
+	"gpt-4.1":      {Input: 2, Output: 8},
+	"gpt-4.1-mini": {Input: 0.4, Output: 1.6},
+	"gpt-4.1-nano": {Input: 0.1, Output: 0.4},
+	"gpt-4o":       {Input: 2.5, Output: 10},
+	"gpt-4o-mini":  {Input: 0.15, Output: 0.6},
+	"o3":           {Input: 2, Output: 8},
+	"o4-mini":      {Input: 1.1, Output: 4.4},
+
+	"claude-opus-4":     {Input: 15, Output: 75},
+	"claude-sonnet-4":   {Input: 3, Output: 15},
+	"claude-3-7-sonnet": {Input: 3, Output: 15},
+	"claude-3-5-sonnet": {Input: 3, Output: 15},
+	"claude-3-5-haiku":  {Input: 0.8, Output: 4},
+
+	"gemini-2.5-pro":   {Input: 1.25, Output: 10},
+	"gemini-2.5-flash": {Input: 0.3, Output: 2.5},
+	"gemini-2.0-flash": {Input: 0.1, Output: 0.4},
+
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+}
+
+// LoadPrices reads a JSON object of model prefixes to prices from path and
+// returns the default prices overridden by it.
+func LoadPrices(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides PriceTable
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, err
+	}
+
+	prices := make(PriceTable, len(DefaultPrices)+len(overrides))
+	for model, price := range DefaultPrices {
+		prices[model] = price
+	}
+	for model, price := range overrides {
+		prices[strings.ToLower(model)] = price
+	}
+	return prices, nil
+}
+
+// Lookup returns the price of model, ignoring any "provider/" prefix.
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	var best Price
+	matched := 0
+	for prefix, price := range t {
+		if strings.HasPrefix(name, prefix) && len(prefix) > matched {
+			best = price
+			matched = len(prefix)
+		}
+	}
+	return best, matched > 0
+}
+
+// Cost is the USD price of a call to model.
+func (t PriceTable) Cost(model string, promptTokens, completionTokens int) float64 {
+	price, _ := t.Lookup(model)
+	return (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1e6
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/prompts"
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm"
+)
+
+// Prompts the application renders. The chat flow doesn't retrieve documents
+// yet, so there is no RAG context prompt; it belongs with the retrieval step.
+const (
+	// ChatSystemPrompt is the system instructions of every chat turn. An
+	// empty result sends none.
+	ChatSystemPrompt = "chat.system"
+)
+
+var ErrPromptNotFound = errors.New("prompt not found")
+
+// BuiltinPrompts are created by SeedPrompts when they have no version yet.
+var BuiltinPrompts = []models.PromptTemplate{
+	{
+		Name:        ChatSystemPrompt,
+		Description: "System instructions sent with every chat turn.",
+		Body:        "{{.persona}}",
+		Variables: []prompts.Variable{
+			{Name: "persona", Type: prompts.TypeString, Description: "System prompt of the chat's persona"},
+			{Name: "date", Type: prompts.TypeString, Description: "Current date, YYYY-MM-DD"},
+		},
+	},
+}
+
+// SeedPrompts stores the first version of the built-in prompts that don't
+// exist yet. Later versions are left alone, so edits survive restarts.
+func SeedPrompts() error {
+	for _, builtin := range BuiltinPrompts {
+		var count int64
+		if err := database.DB.Model(&models.PromptTemplate{}).Where("name = ?", builtin.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := SavePrompt(&builtin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SavePrompt checks the template and stores it as the next version of its
+// name. Invalid templates return prompts.Errors.
+func SavePrompt(tmpl *models.PromptTemplate) error {
+	tmpl.Name = strings.TrimSpace(tmpl.Name)
+	if tmpl.Name == "" {
+		return prompts.Errors{{Message: "name is required"}}
+	}
+	if errs := prompts.Check(tmpl.Body, tmpl.Variables); len(errs) > 0 {
+		return errs
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		err := tx.Model(&models.PromptTemplate{}).Where("name = ?", tmpl.Name).
+			Select("COALESCE(MAX(version), 0)").Scan(&latest).Error
+		if err != nil {
+			return err
+		}
+		tmpl.ID = 0
+		tmpl.Version = latest + 1
+		return tx.Create(tmpl).Error
+	})
+}
+
+// Prompt returns a version of the named prompt, the latest for version 0.
+func Prompt(name string, version int) (*models.PromptTemplate, error) {
+	query := database.DB.Where("name = ?", name)
+	if version > 0 {
+		query = query.Where("version = ?", version)
+	}
+
+	var tmpl models.PromptTemplate
+	if err := query.Order("version desc").First(&tmpl).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPromptNotFound
+		}
+		return nil, err
+	}
+	return &tmpl, nil
+}
+
+// PromptVersions lists every version of the named prompt, newest first.
+func PromptVersions(name string) ([]models.PromptTemplate, error) {
+	var versions []models.PromptTemplate
+	err := database.DB.Where("name = ?", name).Order("version desc").Find(&versions).Error
+	return versions, err
+}
+
+// LatestPrompts lists the latest version of every prompt.
+func LatestPrompts() ([]models.PromptTemplate, error) {
+	latest := database.DB.Model(&models.PromptTemplate{}).Select("name, MAX(version)").Group("name")
+
+	var templates []models.PromptTemplate
+	err := database.DB.Where("(name, version) IN (?)", latest).Order("name").Find(&templates).Error
+	return templates, err
+}
+
+// RenderPrompt renders a version of the named prompt, the latest for
+// version 0, returning the version used.
+func RenderPrompt(name string, version int, values map[string]interface{}) (string, *models.PromptTemplate, error) {
+	tmpl, err := Prompt(name, version)
+	if err != nil {
+		return "", nil, err
+	}
+	text, err := prompts.Render(tmpl.Body, tmpl.Variables, values)
+	return text, tmpl, err
+}
+
+// systemInstructions renders the chat's system prompt. A missing or broken
+// template doesn't stop the chat: the persona's prompt is sent as is.
+func systemInstructions(persona *models.Persona) string {
+	var personaPrompt string
+	if persona != nil {
+		personaPrompt = persona.SystemPrompt
+	}
+
+	text, _, err := RenderPrompt(ChatSystemPrompt, 0, map[string]interface{}{
+		"persona": personaPrompt,
+		"date":    time.Now().UTC().Format(time.DateOnly),
+	})
+	if errors.Is(err, ErrPromptNotFound) {
+		return personaPrompt
+	}
+	if err != nil {
+		log.Warn("Failed to render ", ChatSystemPrompt, " prompt, using the persona's: ", err)
+		return personaPrompt
+	}
+	return strings.TrimSpace(text)
+}
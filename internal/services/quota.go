@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+)
+
+// Plan names. Custom plans take their limits from the user.
+const (
+	PlanFree   = "free"
+	PlanPro    = "pro"
+	PlanCustom = "custom"
+)
+
+// Quota names, as reported in QuotaExceededError.
+const (
+	QuotaMessagesPerDay = "messages_per_day"
+	QuotaTokensPerMonth = "tokens_per_month"
+	QuotaMaxChats       = "max_chats"
+)
+
+var ErrInvalidPlan = errors.New("invalid plan")
+
+// Plan limits what a user can do; zero limits are unlimited.
+type Plan struct {
+	Name           string `json:"name"`
+	MessagesPerDay int    `json:"messages_per_day"`
+	TokensPerMonth int    `json:"tokens_per_month"`
+	MaxChats       int    `json:"max_chats"`
+}
+
+// Plans are the predefined plans.
+var Plans = map[string]Plan{
+	PlanFree: {Name: PlanFree, MessagesPerDay: 50, TokensPerMonth: 200_000, MaxChats: 20},
+	PlanPro:  {Name: PlanPro, MessagesPerDay: 2000, TokensPerMonth: 10_000_000},
+}
+
+// Quota is the state of one limit. Remaining is nil for unlimited quotas and
+// ResetAt for limits that don't reset, like the number of chats.
+type Quota struct {
+	Name      string     `json:"name"`
+	Limit     int        `json:"limit"`
+	Used      int        `json:"used"`
+	Remaining *int       `json:"remaining"`
+	ResetAt   *time.Time `json:"reset_at,omitempty"`
+}
+
+// QuotaExceededError is returned when a user has used up a limit of their
+// plan. Quotas holds every limit of the plan, not only the exceeded one.
+type QuotaExceededError struct {
+	Plan     string  `json:"plan"`
+	Exceeded string  `json:"exceeded"`
+	Quotas   []Quota `json:"quotas"`
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota %s of plan %s exceeded", e.Exceeded, e.Plan)
+}
+
+// RetryAfter is how long until the exceeded quota resets, zero when it
+// doesn't.
+func (e *QuotaExceededError) RetryAfter() time.Duration {
+	for _, quota := range e.Quotas {
+		if quota.Name == e.Exceeded && quota.ResetAt != nil {
+			return time.Until(*quota.ResetAt)
+		}
+	}
+	return 0
+}
+
+// UserPlan returns the limits that apply to the user.
+func UserPlan(user *models.User) Plan {
+	if user.Plan == PlanCustom {
+		return Plan{
+			Name:           PlanCustom,
+			MessagesPerDay: user.MessagesPerDay,
+			TokensPerMonth: user.TokensPerMonth,
+			MaxChats:       user.MaxChats,
+		}
+	}
+	if plan, ok := Plans[user.Plan]; ok {
+		return plan
+	}
+	return Plans[PlanFree]
+}
+
+// SetPlan moves the user to a plan. The limits only apply to custom plans
+// and are cleared otherwise.
+func SetPlan(user *models.User, name string, limits Plan) error {
+	if _, ok := Plans[name]; !ok && name != PlanCustom {
+		return fmt.Errorf("%w: unknown plan %q", ErrInvalidPlan, name)
+	}
+	if name != PlanCustom {
+		limits = Plan{}
+	}
+	if limits.MessagesPerDay < 0 || limits.TokensPerMonth < 0 || limits.MaxChats < 0 {
+		return fmt.Errorf("%w: limits can't be negative", ErrInvalidPlan)
+	}
+
+	user.Plan = name
+	user.MessagesPerDay = limits.MessagesPerDay
+	user.TokensPerMonth = limits.TokensPerMonth
+	user.MaxChats = limits.MaxChats
+	return database.DB.Model(user).
+		Select("Plan", "MessagesPerDay", "TokensPerMonth", "MaxChats").
+		Updates(user).Error
+}
+
+// Quotas reports the user's usage against every limit of their plan.
+func Quotas(user *models.User) ([]Quota, error) {
+	plan := UserPlan(user)
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	nextDay := day.AddDate(0, 0, 1)
+	nextMonth := month.AddDate(0, 1, 0)
+
+	// Messages of deleted chats still count, so deleting a chat doesn't
+	// reset the day. A regenerated answer is a new request too: it's an
+	// assistant message with an earlier sibling.
+	var messages int64
+	err := database.DB.Model(&models.Message{}).
+		Joins("JOIN chats ON chats.id = messages.chat_id").
+		Where("chats.user_id = ? AND messages.created_at >= ?", user.ID, day).
+		Where(`messages.role = 'user' OR (messages.role = 'assistant' AND EXISTS (
+			SELECT 1 FROM messages earlier
+			WHERE earlier.parent_id = messages.parent_id AND earlier.id < messages.id))`).
+		Count(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens int64
+	err = database.DB.Model(&models.UsageRecord{}).
+		Select("COALESCE(SUM(total_tokens), 0)").
+		Where("user_id = ? AND created_at >= ?", user.ID, month).
+		Scan(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var chats int64
+	if err := database.DB.Model(&models.Chat{}).Where("user_id = ?", user.ID).Count(&chats).Error; err != nil {
+		return nil, err
+	}
+
+	return []Quota{
+		newQuota(QuotaMessagesPerDay, plan.MessagesPerDay, int(messages), &nextDay),
+		newQuota(QuotaTokensPerMonth, plan.TokensPerMonth, int(tokens), &nextMonth),
+		newQuota(QuotaMaxChats, plan.MaxChats, int(chats), nil),
+	}, nil
+}
+
+func newQuota(name string, limit, used int, resetAt *time.Time) Quota {
+	quota := Quota{Name: name, Limit: limit, Used: used, ResetAt: resetAt}
+	if limit > 0 {
+		remaining := max(limit-used, 0)
+		quota.Remaining = &remaining
+	}
+	return quota
+}
+
+// CheckQuota returns a *QuotaExceededError when the user can't send another
+// message, or start a chat when newChat is set. It runs before anything is
+// generated; a reply that goes over the token quota is still delivered and
+// the next message is refused.
+func CheckQuota(userID uint, newChat bool) error {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	quotas, err := Quotas(&user)
+	if err != nil {
+		return err
+	}
+	for _, quota := range quotas {
+		if quota.Limit == 0 || (quota.Name == QuotaMaxChats && !newChat) {
+			continue
+		}
+		if quota.Used >= quota.Limit {
+			return &QuotaExceededError{Plan: UserPlan(&user).Name, Exceeded: quota.Name, Quotas: quotas}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/database"
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/gofiber/fiber/v2/log"
+	"gorm.io/gorm"
+)
+
+// Usage aggregation periods.
+const (
+	UsageByDay   = "day"
+	UsageByMonth = "month"
+)
+
+var ErrInvalidUsagePeriod = errors.New("period must be day or month")
+
+// UsageTotals sums the usage records of a period.
+type UsageTotals struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// UsageBucket is the usage of one day or month, starting at Start (UTC).
+type UsageBucket struct {
+	Start time.Time `json:"start"`
+	UsageTotals
+}
+
+// UsageSummary is a user's usage between From and To, split in Buckets of
+// Period.
+type UsageSummary struct {
+	UserID  uint          `json:"user_id"`
+	Period  string        `json:"period"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Total   UsageTotals   `json:"total"`
+	Buckets []UsageBucket `json:"buckets"`
+}
+
+// recordUsage adds a call that answered in the chat to the ledger. The reply
+// is already saved, so a failure is only logged.
+func (s *ChatService) recordUsage(chat *models.Chat, message *models.Message, response *llm.Response) {
+	model := response.Model
+	if model == "" {
+		model = message.ModelName
+	}
+	s.saveUsage(models.UsageRecord{UserID: chat.UserID, ChatID: chat.ID, MessageID: &message.ID}, model, response)
+}
+
+// RecordUsage adds a call made for the user outside of a chat, such as a
+// structured completion, to the ledger. A failure is only logged.
+func (s *ChatService) RecordUsage(userID uint, response *llm.Response) {
+	s.saveUsage(models.UsageRecord{UserID: userID}, response.Model, response)
+}
+
+// saveUsage completes record with the tokens and cost of response.
+func (s *ChatService) saveUsage(record models.UsageRecord, model string, response *llm.Response) {
+	record.Provider = response.Provider
+	record.Model = model
+	record.PromptTokens = response.Usage.PromptTokens
+	record.CompletionTokens = response.Usage.CompletionTokens
+	record.TotalTokens = response.Usage.TotalTokens
+	record.Cost = s.Prices.Cost(model, response.Usage.PromptTokens, response.Usage.CompletionTokens)
+	if record.TotalTokens == 0 {
+		record.TotalTokens = record.PromptTokens + record.CompletionTokens
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		log.Error("Failed to record usage for user ", record.UserID, ": ", err)
+	}
+}
+
+// UserUsage aggregates a user's usage records from from (inclusive) to to
+// (exclusive) by UTC day or month, whatever the database's time zone.
+func UserUsage(userID uint, period string, from, to time.Time) (*UsageSummary, error) {
+	if period != UsageByDay && period != UsageByMonth {
+		return nil, ErrInvalidUsagePeriod
+	}
+
+	summary := &UsageSummary{UserID: userID, Period: period, From: from, To: to, Buckets: []UsageBucket{}}
+	err := database.DB.Model(&models.UsageRecord{}).
+		Select(`date_trunc(?, created_at AT TIME ZONE 'UTC') AS start, COUNT(*) AS requests,
+			SUM(prompt_tokens) AS prompt_tokens, SUM(completion_tokens) AS completion_tokens,
+			SUM(total_tokens) AS total_tokens, SUM(cost) AS cost`, period).
+		Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, from, to).
+		Group("start").
+		Order("start").
+		Scan(&summary.Buckets).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bucket := range summary.Buckets {
+		summary.Total.Requests += bucket.Requests
+		summary.Total.PromptTokens += bucket.PromptTokens
+		summary.Total.CompletionTokens += bucket.CompletionTokens
+		summary.Total.TotalTokens += bucket.TotalTokens
+		summary.Total.Cost += bucket.Cost
+	}
+	return summary, nil
+}
+
+// incrementMessageCount counts a message against the user in a single
+// UPDATE, so concurrent messages aren't lost.
+func incrementMessageCount(userID uint) {
+	err := database.DB.Model(&models.User{}).Where("id = ?", userID).
+		UpdateColumn("message_count", gorm.Expr("message_count + ?", 1)).Error
+	if err != nil {
+		log.Error("Failed to count message for user ", userID, ": ", err)
+	}
+}
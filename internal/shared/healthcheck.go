@@ -1,6 +1,7 @@
 package shared
 
 import (
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/resilience"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/healthcheck"
 )
@@ -12,8 +13,19 @@ func HealthCheck(app *fiber.App) {
 			return true
 		},
 		LivenessEndpoint: "/live",
+		// Not ready once every integration's circuit breaker is open: while
+		// one backend answers, requests can still fail over to it. The
+		// breaker states are returned in the body either way.
 		ReadinessProbe: func(c *fiber.Ctx) bool {
-			return true
+			states := resilience.States()
+			ready := len(states) == 0
+			for _, state := range states {
+				if state.State != resilience.StateOpen {
+					ready = true
+				}
+			}
+			c.JSON(fiber.Map{"ready": ready, "circuit_breakers": states})
+			return ready
 		},
 		ReadinessEndpoint: "/ready",
 	}))
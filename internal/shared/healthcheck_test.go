@@ -0,0 +1,56 @@
+package shared
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/resilience"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestReadiness(t *testing.T) {
+	app := fiber.New()
+	HealthCheck(app)
+
+	ready := func() (int, bool) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/ready", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Ready bool `json:"ready"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body.Ready
+	}
+
+	if status, ok := ready(); status != fiber.StatusOK || !ok {
+		t.Errorf("no breakers: status %d, ready %v, want ready", status, ok)
+	}
+
+	config := resilience.Config{FailureThreshold: 1, OpenTimeout: time.Hour}
+	primary := resilience.NewClient("primary", config).Transport.(*resilience.Transport).Breaker
+	fallback := resilience.NewClient("fallback", config).Transport.(*resilience.Transport).Breaker
+
+	primary.Failure()
+	if status, ok := ready(); status != fiber.StatusOK || !ok {
+		t.Errorf("one breaker open: status %d, ready %v, want ready", status, ok)
+	}
+
+	fallback.Failure()
+	if status, ok := ready(); status != fiber.StatusServiceUnavailable || ok {
+		t.Errorf("every breaker open: status %d, ready %v, want not ready", status, ok)
+	}
+
+	primary.Success()
+	if status, ok := ready(); status != fiber.StatusOK || !ok {
+		t.Errorf("one breaker closed again: status %d, ready %v, want ready", status, ok)
+	}
+}
@@ -0,0 +1,158 @@
+package tokenizer
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pre-tokenization splits text into pieces before byte pair merging. The
+// patterns are tiktoken's, minus the `\s+(?!\S)` alternative: Go's regexp has
+// no lookahead, so whitespace runs are handled in split. `\s` is spelled out
+// as a class because tiktoken matches Unicode whitespace, Go only ASCII.
+const (
+	space    = `\t\n\v\f\r \x{85}\p{Z}`
+	cl100kRe = `^(?:(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^` + space + `\p{L}\p{N}]+[\r\n]*|[` + space + `]*[\r\n]+)`
+	o200kRe  = `^(?:[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}| ?[^` + space + `\p{L}\p{N}]+[\r\n/]*|[` + space + `]*[\r\n]+)`
+)
+
+// Encoding is a byte pair encoding compatible with OpenAI's tiktoken.
+type Encoding struct {
+	Name    string
+	ranks   map[string]int
+	decoder map[int]string
+	pattern *regexp.Regexp
+}
+
+func newEncoding(name string, pattern string, ranks map[string]int) *Encoding {
+	decoder := make(map[int]string, len(ranks))
+	for token, rank := range ranks {
+		decoder[rank] = token
+	}
+	return &Encoding{
+		Name:    name,
+		ranks:   ranks,
+		decoder: decoder,
+		pattern: regexp.MustCompile(pattern),
+	}
+}
+
+// split cuts text into pre-tokenization pieces.
+func (e *Encoding) split(text string) []string {
+	pieces := []string{}
+	for len(text) > 0 {
+		n := 0
+		if loc := e.pattern.FindStringIndex(text); loc != nil && loc[1] > 0 {
+			n = loc[1]
+		} else {
+			n = whitespaceRun(text)
+		}
+		pieces = append(pieces, text[:n])
+		text = text[n:]
+	}
+	return pieces
+}
+
+// whitespaceRun emulates `\s+(?!\S)|\s+`: a whitespace run leaves its last
+// character to the next piece when a non-space follows, so " word" stays
+// together.
+func whitespaceRun(text string) int {
+	end, last := 0, 0
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		last = end
+		end += size
+	}
+
+	switch {
+	case end == 0:
+		// Not whitespace; the patterns cover everything else, but never
+		// loop forever on input they don't.
+		_, size := utf8.DecodeRuneInString(text)
+		return size
+	case end == len(text) || last == 0:
+		return end
+	default:
+		return last
+	}
+}
+
+// merge applies byte pair merges to a piece that isn't a token itself.
+func (e *Encoding) merge(piece string) []int {
+	// parts holds the start offset of every current part, plus the end.
+	parts := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+
+	rank := func(i int) int {
+		if i+2 >= len(parts) {
+			return math.MaxInt
+		}
+		if r, ok := e.ranks[piece[parts[i]:parts[i+2]]]; ok {
+			return r
+		}
+		return math.MaxInt
+	}
+
+	for len(parts) > 2 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i < len(parts)-2; i++ {
+			if r := rank(i); r < bestRank {
+				best, bestRank = i, r
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+
+	tokens := make([]int, 0, len(parts)-1)
+	for i := 0; i < len(parts)-1; i++ {
+		tokens = append(tokens, e.ranks[piece[parts[i]:parts[i+1]]])
+	}
+	return tokens
+}
+
+// Encode returns the tokens of text. Special tokens are encoded as plain text.
+func (e *Encoding) Encode(text string) []int {
+	tokens := []int{}
+	for _, piece := range e.split(text) {
+		if token, ok := e.ranks[piece]; ok {
+			tokens = append(tokens, token)
+			continue
+		}
+		tokens = append(tokens, e.merge(piece)...)
+	}
+	return tokens
+}
+
+func (e *Encoding) Decode(tokens []int) string {
+	var text strings.Builder
+	for _, token := range tokens {
+		text.WriteString(e.decoder[token])
+	}
+	return text.String()
+}
+
+func (e *Encoding) Count(text string) int {
+	return len(e.Encode(text))
+}
+
+// KeepLast drops tokens from the start of text until at most max remain.
+func (e *Encoding) KeepLast(text string, max int) string {
+	tokens := e.Encode(text)
+	if len(tokens) <= max {
+		return text
+	}
+	// A token may hold part of a multi-byte character; drop what's cut.
+	return strings.ToValidUTF8(e.Decode(tokens[len(tokens)-max:]), "")
+}
@@ -0,0 +1,69 @@
+package tokenizer
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testRanks merge "bc" before "ab", so merging the lowest rank first and
+// merging left to right give different tokens.
+var testRanks = map[string]int{"a": 0, "b": 1, "c": 2, "d": 3, "bc": 4, "ab": 5, "cd": 6, " ": 7, "abc": 8}
+
+func TestEncodingMerge(t *testing.T) {
+	enc := newEncoding("test", cl100kRe, testRanks)
+
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"abcd", []int{8, 3}}, // bc, then abc; left to right would give ab, cd
+		{"abc", []int{8}},     // A piece that is a token itself
+		{"cd", []int{6}},
+		{"dcba", []int{3, 2, 1, 0}},
+		{"bcd", []int{4, 3}},
+		{"abcd abcd", []int{8, 3, 7, 8, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := enc.Encode(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encode(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+			if decoded := enc.Decode(got); decoded != tt.text {
+				t.Errorf("Decode(Encode(%q)) = %q", tt.text, decoded)
+			}
+			if count := enc.Count(tt.text); count != len(tt.want) {
+				t.Errorf("Count(%q) = %d, want %d", tt.text, count, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestEncodingSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		text    string
+		want    []string
+	}{
+		{"contractions", cl100kRe, "I'm sure they'll", []string{"I", "'m", " sure", " they", "'ll"}},
+		{"uppercase contraction", cl100kRe, "DON'T", []string{"DON", "'T"}},
+		{"digits in threes", cl100kRe, "1234567", []string{"123", "456", "7"}},
+		{"whitespace run", cl100kRe, "hello   world", []string{"hello", "  ", " world"}},
+		{"trailing whitespace", cl100kRe, "end  ", []string{"end", "  "}},
+		{"newlines", cl100kRe, "a\n\nb", []string{"a", "\n\n", "b"}},
+		{"punctuation", cl100kRe, "x = 1;", []string{"x", " =", " ", "1", ";"}},
+		{"unicode space", cl100kRe, "a  b", []string{"a", " ", " b"}},
+		{"camel case", o200kRe, "HelloWorld", []string{"Hello", "World"}},
+		{"o200k contraction", o200kRe, "we'd go", []string{"we'd", " go"}},
+		{"o200k digits", o200kRe, "2024!", []string{"202", "4", "!"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newEncoding("test", tt.pattern, nil).split(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("split(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// encodingsURL is where missing ranks files are downloaded from.
+var encodingsURL = "https://openaipublic.blob.core.windows.net/encodings/"
+
+type encodingSpec struct {
+	pattern string
+	sha256  string
+}
+
+var encodingSpecs = map[string]encodingSpec{
+	"cl100k_base": {cl100kRe, "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7"},
+	"o200k_base":  {o200kRe, "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d"},
+}
+
+// Failed loads are retried after encodingRetry, doubling up to
+// maxEncodingRetry while they keep failing.
+const (
+	encodingRetry    = time.Minute
+	maxEncodingRetry = time.Hour
+)
+
+type loadFailure struct {
+	err     error
+	backoff time.Duration
+	retryAt time.Time
+}
+
+var encodings = struct {
+	sync.Mutex
+	loaded map[string]*Encoding
+	failed map[string]loadFailure
+	group  singleflight.Group
+}{loaded: map[string]*Encoding{}, failed: map[string]loadFailure{}}
+
+// GetEncoding returns the named encoding, loading its ranks on first use.
+// The load runs once for concurrent callers and outside the lock, so counts
+// with other encodings don't wait for a download. A failed load is
+// remembered for a while, so callers fall back to estimates instead of
+// retrying the download on every request.
+func GetEncoding(name string) (*Encoding, error) {
+	encodings.Lock()
+	if enc, ok := encodings.loaded[name]; ok {
+		encodings.Unlock()
+		return enc, nil
+	}
+	if failure, ok := encodings.failed[name]; ok && time.Now().Before(failure.retryAt) {
+		encodings.Unlock()
+		return nil, failure.err
+	}
+	encodings.Unlock()
+
+	spec, ok := encodingSpecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+
+	enc, err, _ := encodings.group.Do(name, func() (interface{}, error) {
+		ranks, err := loadRanks(name, spec.sha256)
+
+		encodings.Lock()
+		defer encodings.Unlock()
+		if err != nil {
+			warnFallback(name, err)
+			backoff := encodingRetry
+			if previous, ok := encodings.failed[name]; ok {
+				backoff = min(previous.backoff*2, maxEncodingRetry)
+			}
+			encodings.failed[name] = loadFailure{err: err, backoff: backoff, retryAt: time.Now().Add(backoff)}
+			return nil, err
+		}
+
+		enc := newEncoding(name, spec.pattern, ranks)
+		encodings.loaded[name] = enc
+		delete(encodings.failed, name)
+		return enc, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return enc.(*Encoding), nil
+}
+
+// Preload loads the given encodings so the first chat request doesn't pay for
+// the download.
+func Preload(names ...string) {
+	for _, name := range names {
+		GetEncoding(name)
+	}
+}
+
+// loadRanks reads <name>.tiktoken from TOKENIZER_DIR, then from the user cache
+// directory, and downloads it into the cache as a last resort.
+func loadRanks(name, checksum string) (map[string]int, error) {
+	file := name + ".tiktoken"
+
+	if dir := os.Getenv("TOKENIZER_DIR"); dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err == nil {
+			return parseRanks(data, checksum)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	cache := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		cache = filepath.Join(dir, "golang-chat-ai", "tokenizer", file)
+		if data, err := os.ReadFile(cache); err == nil {
+			if ranks, err := parseRanks(data, checksum); err == nil {
+				return ranks, nil
+			}
+		}
+	}
+
+	data, err := download(encodingsURL + file)
+	if err != nil {
+		return nil, err
+	}
+	ranks, err := parseRanks(data, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != "" {
+		if err := os.MkdirAll(filepath.Dir(cache), 0o755); err == nil {
+			os.WriteFile(cache, data, 0o644)
+		}
+	}
+	return ranks, nil
+}
+
+func download(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseRanks parses the tiktoken format: one base64 token and its rank per
+// line.
+func parseRanks(data []byte, checksum string) (map[string]int, error) {
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return nil, errors.New("tokenizer ranks checksum mismatch")
+	}
+
+	ranks := make(map[string]int, 200000)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		token, rank, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			return nil, fmt.Errorf("invalid ranks line %q", line)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(token))
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(string(rank))
+		if err != nil {
+			return nil, err
+		}
+		ranks[string(decoded)] = n
+	}
+	return ranks, scanner.Err()
+}
@@ -0,0 +1,143 @@
+package tokenizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveRanks serves a small ranks file as the test_base encoding from a
+// local server, with an empty cache, and returns the number of downloads
+// and a way to publish the right checksum.
+func serveRanks(t *testing.T) (downloads *atomic.Int32, fixChecksum func()) {
+	t.Helper()
+	ranks := "YQ== 0\nYg== 1\nYWI= 2\n" // a, b, ab
+	downloads = &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/test_base.tiktoken" {
+			http.NotFound(w, r)
+			return
+		}
+		downloads.Add(1)
+		w.Write([]byte(ranks))
+	}))
+
+	previousURL := encodingsURL
+	encodingsURL = server.URL + "/"
+	t.Setenv("TOKENIZER_DIR", "")
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	encodingSpecs["test_base"] = encodingSpec{cl100kRe, strings.Repeat("0", 64)}
+	t.Cleanup(func() {
+		server.Close()
+		encodingsURL = previousURL
+		delete(encodingSpecs, "test_base")
+		encodings.Lock()
+		delete(encodings.loaded, "test_base")
+		delete(encodings.failed, "test_base")
+		encodings.Unlock()
+	})
+
+	sum := sha256.Sum256([]byte(ranks))
+	return downloads, func() {
+		encodingSpecs["test_base"] = encodingSpec{cl100kRe, hex.EncodeToString(sum[:])}
+	}
+}
+
+// expireFailure makes the next GetEncoding retry the failed load.
+func expireFailure(name string) {
+	encodings.Lock()
+	defer encodings.Unlock()
+	failure := encodings.failed[name]
+	failure.retryAt = time.Now().Add(-time.Second)
+	encodings.failed[name] = failure
+}
+
+func failedBackoff(name string) time.Duration {
+	encodings.Lock()
+	defer encodings.Unlock()
+	return encodings.failed[name].backoff
+}
+
+func TestGetEncodingChecksumMismatch(t *testing.T) {
+	downloads, fixChecksum := serveRanks(t)
+
+	_, err := GetEncoding("test_base")
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("err = %v, want a checksum mismatch", err)
+	}
+	if backoff := failedBackoff("test_base"); backoff != encodingRetry {
+		t.Errorf("backoff = %v, want %v", backoff, encodingRetry)
+	}
+
+	// The failure is remembered until the backoff is over.
+	if _, err := GetEncoding("test_base"); err == nil || downloads.Load() != 1 {
+		t.Fatalf("err = %v after %d downloads, want the remembered failure", err, downloads.Load())
+	}
+
+	expireFailure("test_base")
+	if _, err := GetEncoding("test_base"); err == nil || downloads.Load() != 2 {
+		t.Fatalf("err = %v after %d downloads, want a retried download", err, downloads.Load())
+	}
+	if backoff := failedBackoff("test_base"); backoff != 2*encodingRetry {
+		t.Errorf("backoff = %v after two failures, want %v", backoff, 2*encodingRetry)
+	}
+
+	encodings.Lock()
+	encodings.failed["test_base"] = loadFailure{err: err, backoff: maxEncodingRetry, retryAt: time.Now().Add(-time.Second)}
+	encodings.Unlock()
+	GetEncoding("test_base")
+	if backoff := failedBackoff("test_base"); backoff != maxEncodingRetry {
+		t.Errorf("backoff = %v, want it capped at %v", backoff, maxEncodingRetry)
+	}
+
+	fixChecksum()
+	expireFailure("test_base")
+	enc, err := GetEncoding("test_base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := enc.Encode("ab"); len(got) != 1 || got[0] != 2 {
+		t.Errorf("Encode(ab) = %v, want [2]", got)
+	}
+	encodings.Lock()
+	_, failed := encodings.failed["test_base"]
+	encodings.Unlock()
+	if failed {
+		t.Error("the failure wasn't cleared after a successful load")
+	}
+
+	cache, _ := os.UserCacheDir()
+	if _, err := os.Stat(filepath.Join(cache, "golang-chat-ai", "tokenizer", "test_base.tiktoken")); err != nil {
+		t.Errorf("the download wasn't cached: %v", err)
+	}
+}
+
+func TestGetEncodingLoadsOnce(t *testing.T) {
+	downloads, fixChecksum := serveRanks(t)
+	fixChecksum()
+
+	done := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func() {
+			_, err := GetEncoding("test_base")
+			done <- err
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := GetEncoding("test_base"); err != nil || downloads.Load() != 1 {
+		t.Errorf("err = %v after %d downloads, want a single download", err, downloads.Load())
+	}
+}
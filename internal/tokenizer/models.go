@@ -0,0 +1,85 @@
+package tokenizer
+
+import (
+	"strings"
+	"sync"
+)
+
+const DefaultContextWindow = 8192
+
+// ModelInfo describes how a model counts tokens and how many fit in its
+// context window, prompt and reply together.
+type ModelInfo struct {
+	Name          string `json:"name"`
+	Encoding      string `json:"encoding,omitempty"` // Empty when counts are estimated
+	ContextWindow int    `json:"context_window"`
+}
+
+// Known models, matched by name prefix. Longer prefixes win, so "gpt-4o"
+// takes precedence over "gpt-4".
+var registry = struct {
+	sync.RWMutex
+	models []ModelInfo
+}{models: []ModelInfo{
+	{Name: "gpt-5", Encoding: "o200k_base", ContextWindow: 400000},
+	{Name: "gpt-4.1", Encoding: "o200k_base", ContextWindow: 1047576},
+	{Name: "gpt-4o", Encoding: "o200k_base", ContextWindow: 128000},
+	{Name: "gpt-4-turbo", Encoding: "cl100k_base", ContextWindow: 128000},
+	{Name: "gpt-4-32k", Encoding: "cl100k_base", ContextWindow: 32768},
+	{Name: "gpt-4", Encoding: "cl100k_base", ContextWindow: 8192},
+	{Name: "gpt-3.5-turbo", Encoding: "cl100k_base", ContextWindow: 16385},
+	{Name: "o1", Encoding: "o200k_base", ContextWindow: 200000},
+	{Name: "o3", Encoding: "o200k_base", ContextWindow: 200000},
+	{Name: "o4", Encoding: "o200k_base", ContextWindow: 200000},
+	{Name: "text-embedding-3", Encoding: "cl100k_base", ContextWindow: 8191},
+	{Name: "text-embedding-ada-002", Encoding: "cl100k_base", ContextWindow: 8191},
+	{Name: "claude", ContextWindow: 200000},
+	{Name: "gemini-1.5-pro", ContextWindow: 2097152},
+	{Name: "gemini", ContextWindow: 1048576},
+	{Name: "llama3.1", ContextWindow: 131072},
+	{Name: "llama3.2", ContextWindow: 131072},
+	{Name: "llama3.3", ContextWindow: 131072},
+	{Name: "llama3", ContextWindow: 8192},
+	{Name: "llama2", ContextWindow: 4096},
+	{Name: "mistral", ContextWindow: 32768},
+	{Name: "qwen2.5", ContextWindow: 32768},
+	{Name: "gemma", ContextWindow: 8192},
+}}
+
+// RegisterModel adds or replaces a model entry.
+func RegisterModel(info ModelInfo) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for i, model := range registry.models {
+		if model.Name == info.Name {
+			registry.models[i] = info
+			return
+		}
+	}
+	registry.models = append(registry.models, info)
+}
+
+// LookupModel returns what is known about model. Provider prefixes such as
+// "openai/" or "models/" are ignored, and unknown models get the default
+// context window with estimated counts.
+func LookupModel(model string) ModelInfo {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	registry.RLock()
+	defer registry.RUnlock()
+
+	best := ModelInfo{Name: model, ContextWindow: DefaultContextWindow}
+	matched := 0
+	for _, info := range registry.models {
+		if strings.HasPrefix(name, info.Name) && len(info.Name) > matched {
+			best = info
+			best.Name = model
+			matched = len(info.Name)
+		}
+	}
+	return best
+}
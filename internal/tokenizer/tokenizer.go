@@ -0,0 +1,90 @@
+// Package tokenizer counts tokens the way LLM providers do, so prompts can be
+// fitted into a model's context window before they are sent.
+package tokenizer
+
+import (
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2/log"
+)
+
+// Chat formats add a few tokens around every message and prime the reply,
+// as documented for OpenAI's chat models.
+const (
+	MessageOverhead = 3
+	ReplyOverhead   = 3
+)
+
+type Tokenizer interface {
+	Count(text string) int
+	// KeepLast drops tokens from the start of text until at most max remain.
+	KeepLast(text string, max int) string
+}
+
+// Estimator approximates token counts for models whose encoding is unknown
+// or couldn't be loaded: about four bytes per token for ASCII text and a
+// token per character otherwise.
+type Estimator struct{}
+
+func (Estimator) Count(text string) int {
+	ascii, other := runeClasses(text)
+	return estimate(ascii, other)
+}
+
+// KeepLast drops runes from the start of text, updating the estimate as it
+// goes, so a cut never lands inside a multi-byte character.
+func (Estimator) KeepLast(text string, max int) string {
+	ascii, other := runeClasses(text)
+	for i, r := range text {
+		if estimate(ascii, other) <= max {
+			return text[i:]
+		}
+		if r < utf8.RuneSelf {
+			ascii--
+		} else {
+			other--
+		}
+	}
+	return ""
+}
+
+// runeClasses counts the ASCII and other runes of text.
+func runeClasses(text string) (ascii, other int) {
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return ascii, other
+}
+
+func estimate(ascii, other int) int {
+	return (ascii+3)/4 + other
+}
+
+// Exact reports whether t counts real tokens rather than estimating them.
+func Exact(t Tokenizer) bool {
+	_, ok := t.(*Encoding)
+	return ok
+}
+
+// ForModel returns the tokenizer for model, or an Estimator when its
+// encoding is unknown or unavailable.
+func ForModel(model string) Tokenizer {
+	info := LookupModel(model)
+	if info.Encoding == "" {
+		return Estimator{}
+	}
+
+	enc, err := GetEncoding(info.Encoding)
+	if err != nil {
+		return Estimator{}
+	}
+	return enc
+}
+
+func warnFallback(name string, err error) {
+	log.Warn("Tokenizer encoding ", name, " unavailable, estimating token counts: ", err)
+}
@@ -0,0 +1,36 @@
+package tokenizer
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEstimatorKeepLast(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want string
+	}{
+		{"fits", "hello", 10, "hello"},
+		{"ascii", "aaaaaaaaaaaaaaaa", 2, "aaaaaaaa"},
+		{"accent at the end", "aaaaaaaé", 1, "é"},
+		{"emoji at the end", "aaaaaaaaaaaa😀", 1, "😀"},
+		{"only multibyte", "ééééé", 2, "éé"},
+		{"nothing kept", "aaaaé", 0, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Estimator{}.KeepLast(test.text, test.max)
+			if got != test.want {
+				t.Errorf("KeepLast(%q, %d) = %q, want %q", test.text, test.max, got, test.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("KeepLast(%q, %d) returned invalid UTF-8 %q", test.text, test.max, got)
+			}
+			if count := (Estimator{}).Count(got); count > test.max {
+				t.Errorf("KeepLast(%q, %d) kept %d tokens", test.text, test.max, count)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Builtin tools, enabled by name through LLM_TOOLS.
+var Builtin = map[string]Tool{
+	"current_time": {
+		Name:        "current_time",
+		Description: "Returns the current date and time, optionally in an IANA time zone such as Europe/Madrid.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"timezone": map[string]interface{}{
+					"type":        "string",
+					"description": "IANA time zone name, UTC when empty",
+				},
+			},
+		},
+		Handler: currentTime,
+	},
+}
+
+func currentTime(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return "", err
+	}
+
+	location := time.UTC
+	if args.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(args.Timezone); err != nil {
+			return "", fmt.Errorf("unknown time zone %q", args.Timezone)
+		}
+	}
+	return time.Now().In(location).Format(time.RFC3339), nil
+}
@@ -0,0 +1,97 @@
+// Package tools holds the Go functions the model can call during a chat.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+)
+
+var ErrUnknownTool = errors.New("unknown tool")
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Handler runs a tool with the JSON arguments the model produced and returns
+// the result passed back to it.
+type Handler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON Schema of the arguments object.
+	Parameters map[string]interface{}
+	Handler    Handler
+}
+
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+	names []string // Registration order, so tools are advertised stably
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tools: map[string]Tool{}}
+}
+
+func (r *Registry) Register(tool Tool) error {
+	if !validName.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name %q", tool.Name)
+	}
+	if tool.Handler == nil {
+		return fmt.Errorf("tool %s has no handler", tool.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tools[tool.Name]; ok {
+		return fmt.Errorf("tool %s already registered", tool.Name)
+	}
+	r.tools[tool.Name] = tool
+	r.names = append(r.names, tool.Name)
+	return nil
+}
+
+// Definitions returns the tools as advertised to the model.
+func (r *Registry) Definitions() []llm.Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definitions := make([]llm.Tool, 0, len(r.names))
+	for _, name := range r.names {
+		tool := r.tools[name]
+		definitions = append(definitions, llm.Tool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		})
+	}
+	return definitions
+}
+
+// Execute runs the call. Arguments that aren't a JSON object are rejected
+// before reaching the handler.
+func (r *Registry) Execute(ctx context.Context, call llm.ToolCall) (string, error) {
+	r.mu.RLock()
+	tool, ok := r.tools[call.Name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownTool, call.Name)
+	}
+
+	arguments := json.RawMessage(call.Arguments)
+	if call.Arguments == "" {
+		arguments = json.RawMessage(`{}`)
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(arguments, &object); err != nil {
+		return "", fmt.Errorf("invalid arguments for %s: %w", call.Name, err)
+	}
+
+	return tool.Handler(ctx, arguments)
+}
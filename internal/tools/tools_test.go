@@ -0,0 +1,120 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/LDTorres/golang-chat-ai/internal/integrations/llm"
+)
+
+func echo(ctx context.Context, arguments json.RawMessage) (string, error) {
+	return string(arguments), nil
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		tool    Tool
+		wantErr bool
+	}{
+		{name: "valid", tool: Tool{Name: "get_weather-2", Handler: echo}},
+		{name: "longest name", tool: Tool{Name: strings.Repeat("a", 64), Handler: echo}},
+		{name: "empty name", tool: Tool{Name: "", Handler: echo}, wantErr: true},
+		{name: "space in name", tool: Tool{Name: "get weather", Handler: echo}, wantErr: true},
+		{name: "dot in name", tool: Tool{Name: "weather.get", Handler: echo}, wantErr: true},
+		{name: "name too long", tool: Tool{Name: strings.Repeat("a", 65), Handler: echo}, wantErr: true},
+		{name: "no handler", tool: Tool{Name: "lookup"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewRegistry().Register(tt.tool)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Register(Tool{Name: "lookup", Handler: echo}); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(Tool{Name: "lookup", Handler: echo}); err == nil {
+		t.Fatal("registering lookup twice succeeded")
+	}
+	if got := len(registry.Definitions()); got != 1 {
+		t.Errorf("got %d definitions, want 1", got)
+	}
+}
+
+func TestDefinitionsKeepOrder(t *testing.T) {
+	registry := NewRegistry()
+	names := []string{"zeta", "alpha", "mid"}
+	for _, name := range names {
+		if err := registry.Register(Tool{Name: name, Description: name + " tool", Handler: echo}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	definitions := registry.Definitions()
+	if len(definitions) != len(names) {
+		t.Fatalf("got %d definitions, want %d", len(definitions), len(names))
+	}
+	for i, name := range names {
+		if definitions[i].Name != name || definitions[i].Description != name+" tool" {
+			t.Errorf("definition %d = %+v, want %s", i, definitions[i], name)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name      string
+		call      llm.ToolCall
+		want      string
+		wantErr   bool
+		unknown   bool
+		wantCalls int
+	}{
+		{name: "object", call: llm.ToolCall{Name: "echo", Arguments: `{"city":"Paris"}`}, want: `{"city":"Paris"}`, wantCalls: 1},
+		{name: "empty arguments", call: llm.ToolCall{Name: "echo"}, want: `{}`, wantCalls: 1},
+		{name: "array", call: llm.ToolCall{Name: "echo", Arguments: `[1]`}, wantErr: true},
+		{name: "string", call: llm.ToolCall{Name: "echo", Arguments: `"Paris"`}, wantErr: true},
+		{name: "number", call: llm.ToolCall{Name: "echo", Arguments: `1`}, wantErr: true},
+		{name: "invalid JSON", call: llm.ToolCall{Name: "echo", Arguments: `{"city":`}, wantErr: true},
+		{name: "unknown tool", call: llm.ToolCall{Name: "missing", Arguments: `{}`}, wantErr: true, unknown: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			registry := NewRegistry()
+			err := registry.Register(Tool{Name: "echo", Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+				calls++
+				return echo(ctx, arguments)
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := registry.Execute(context.Background(), tt.call)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrUnknownTool) != tt.unknown {
+				t.Errorf("Execute() error = %v, unknown tool %v", err, tt.unknown)
+			}
+			if got != tt.want {
+				t.Errorf("Execute() = %q, want %q", got, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler ran %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/LDTorres/golang-chat-ai/internal/database"
 	v1 "github.com/LDTorres/golang-chat-ai/internal/http/v1"
 	"github.com/LDTorres/golang-chat-ai/internal/models"
+	"github.com/LDTorres/golang-chat-ai/internal/services"
 	"github.com/LDTorres/golang-chat-ai/internal/shared"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/template/mustache/v2"
@@ -21,7 +25,16 @@ func main() {
 
 	// Database
 	database.Connect()
-	database.DB.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{})
+	database.DB.AutoMigrate(&models.User{}, &models.Chat{}, &models.Message{}, &models.EmbeddingCache{}, &models.Persona{}, &models.PromptTemplate{}, &models.UsageRecord{}, &models.Attachment{})
+	if err := services.BackfillBranches(); err != nil {
+		log.Fatal("Failed to link the messages of existing chats: ", err)
+	}
+	if err := services.SeedPersonas(); err != nil {
+		log.Fatal("Failed to seed personas: ", err)
+	}
+	if err := services.SeedPrompts(); err != nil {
+		log.Fatal("Failed to seed prompts: ", err)
+	}
 
 	// Create a new engine
 	engine := mustache.New("./views", ".mustache")
@@ -29,6 +42,8 @@ func main() {
 	// Create app
 	app := fiber.New(fiber.Config{
 		Views: engine,
+		// Room for a message with every attachment at the maximum size.
+		BodyLimit: services.MaxAttachments*services.MaxAttachmentSize + 1<<20,
 	})
 
 	// Logger
@@ -60,7 +75,19 @@ func main() {
 	// API routes
 	v1.ApiV1(app)
 
+	// Stop on SIGINT or SIGTERM, giving the requests in flight some time to
+	// finish.
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		app.ShutdownWithTimeout(30 * time.Second)
+	}()
+
 	HOST := os.Getenv("HOST")
 	PORT := os.Getenv("PORT")
-	log.Fatal(app.Listen(HOST + ":" + PORT))
+	if err := app.Listen(HOST + ":" + PORT); err != nil {
+		log.Fatal(err)
+	}
+	v1.CloseLLM()
 }